@@ -0,0 +1,279 @@
+package botclass
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/infodancer/implog/resolver"
+	"github.com/infodancer/implog/useragent"
+)
+
+// Traffic classes
+const (
+	// Human is traffic with no sign of automation
+	Human = "human"
+	// Bot is a client that identifies itself as a bot or tool but not as a known crawler
+	Bot = "bot"
+	// Crawler is a client claiming to be a known search engine crawler that could not be verified
+	Crawler = "crawler"
+	// VerifiedCrawler is a known search engine crawler whose address resolves to the crawler's own domain
+	VerifiedCrawler = "verified-crawler"
+	// FakeCrawler is a client claiming to be a known crawler whose address does not belong to it
+	FakeCrawler = "fake-crawler"
+	// Monitor is an uptime or performance checker
+	Monitor = "monitor"
+	// Scanner is a vulnerability scanner, identified by its user agent or by probing for well known weaknesses
+	Scanner = "scanner"
+	// Automated is traffic from an address that fetched robots.txt or made requests faster than a person could
+	Automated = "automated"
+)
+
+// Classes lists the traffic classes
+var Classes = []string{Human, Bot, Crawler, VerifiedCrawler, FakeCrawler, Monitor, Scanner, Automated}
+
+// IsClass reports whether a name is one of the traffic classes
+func IsClass(name string) bool {
+	for _, c := range Classes {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultRateLimit is the number of requests per minute from one address above which it is treated as automated
+const DefaultRateLimit = 120
+
+// crawler describes a search engine crawler that can be verified by reverse and forward DNS
+type crawler struct {
+	token   string
+	domains []string
+}
+
+var crawlers = []crawler{
+	{"googlebot", []string{".googlebot.com", ".google.com", ".googleusercontent.com"}},
+	{"adsbot-google", []string{".googlebot.com", ".google.com"}},
+	{"bingbot", []string{".search.msn.com"}},
+	{"msnbot", []string{".search.msn.com"}},
+	{"applebot", []string{".applebot.apple.com"}},
+	{"yandex", []string{".yandex.ru", ".yandex.net", ".yandex.com"}},
+	{"baiduspider", []string{".baidu.com", ".baidu.jp"}},
+	{"slurp", []string{".crawl.yahoo.net"}},
+}
+
+var monitorWords = []string{"uptimerobot", "pingdom", "statuscake", "site24x7", "check_http", "monitis", "newrelicpinger", "betteruptime", "better uptime bot", "updown.io", "freshping", "hetrixtools", "nagios", "zabbix"}
+
+var scannerWords = []string{"sqlmap", "nikto", "nmap", "masscan", "zgrab", "nuclei", "wpscan", "dirbuster", "gobuster", "ffuf", "acunetix", "netsparker", "openvas", "nessus", "qualys", "w3af", "censysinspect", "expanse"}
+
+// probePaths are requested by scanners looking for exposed files and admin pages
+var probePaths = []string{"/.env", "/.git/", "/wp-login.php", "/xmlrpc.php", "/phpmyadmin", "/pma/", "/.aws/", "/config.php", "/wp-config.php", "/cgi-bin/", "/.ds_store", "/server-status", "/actuator", "/vendor/phpunit", "/boaform", "/hnap1"}
+
+// probeLimit is the number of failed probes after which an address is treated as a scanner
+const probeLimit = 3
+
+// forgetAfter is how long an address that was found to be automated or a scanner is remembered after its last
+// request; other addresses are forgotten once their rate window has passed
+const forgetAfter = time.Hour
+
+// retryAfter is how long a crawler's address waits for its verification before it is queued again,
+// since lookups that fail temporarily are never answered
+const retryAfter = time.Minute
+
+type ipState struct {
+	windowStart time.Time
+	lastSeen    time.Time
+	count       int
+	probes      int
+	robots      bool
+	automated   bool
+	scanner     bool
+}
+
+// flagged reports whether anything was found about the address that outlasts its rate window
+func (s *ipState) flagged() bool {
+	return s.probes > 0 || s.robots || s.automated || s.scanner
+}
+
+// Classifier assigns a traffic class to each request.
+// Classification depends on earlier requests from the same address, so requests should be classified in log order;
+// an address is only treated as automated or as a scanner from the point at which it is detected.
+// Likewise a known crawler is only classified as verified or fake once its address has been looked up
+// in the background; until then it is an unverified crawler.
+type Classifier struct {
+	resolver  *resolver.Resolver
+	rateLimit int
+	mutex     *sync.Mutex
+	ips       map[string]*ipState
+	swept     time.Time
+	verified  map[string]string
+	queued    map[string]time.Time
+}
+
+// New creates a classifier that treats addresses making more than rateLimit requests a minute as automated.
+// Known crawlers are verified with the resolver, which the classifier starts and which should not be shared;
+// if it is nil, they are classified as unverified crawlers.
+func New(r *resolver.Resolver, rateLimit int) *Classifier {
+	if rateLimit <= 0 {
+		rateLimit = DefaultRateLimit
+	}
+	result := Classifier{}
+	result.resolver = r
+	result.rateLimit = rateLimit
+	result.mutex = &sync.Mutex{}
+	result.ips = make(map[string]*ipState)
+	result.verified = make(map[string]string)
+	result.queued = make(map[string]time.Time)
+	if r != nil {
+		r.Start(result.record)
+	}
+	return &result
+}
+
+// Close waits for the crawler verifications already queued
+func (c *Classifier) Close() {
+	if c.resolver != nil {
+		c.resolver.Close()
+	}
+}
+
+// Verified returns the class of an address that claimed to be a known crawler, once it has been looked up
+func (c *Classifier) Verified(ip string) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	class, ok := c.verified[ip]
+	return class, ok
+}
+
+// Classify returns the traffic class of a request
+func (c *Classifier) Classify(ip string, ua string, uri string, status int64, timestamp time.Time) string {
+	lowerUA := strings.ToLower(ua)
+	lowerURI := strings.ToLower(uri)
+
+	c.mutex.Lock()
+	state := c.observe(ip, lowerURI, status, timestamp)
+	scanner, automated := state.scanner, state.automated || state.robots
+	c.mutex.Unlock()
+
+	if scanner || containsAny(lowerUA, scannerWords) {
+		return Scanner
+	}
+	if containsAny(lowerUA, monitorWords) {
+		return Monitor
+	}
+	for _, cr := range crawlers {
+		if strings.Contains(lowerUA, cr.token) {
+			return c.verify(ip, cr)
+		}
+	}
+	if useragent.IsBotAgent(ua) {
+		return Bot
+	}
+	if automated {
+		return Automated
+	}
+	return Human
+}
+
+// observe updates what is known about an address from a request; the caller must hold the mutex
+func (c *Classifier) observe(ip string, lowerURI string, status int64, timestamp time.Time) *ipState {
+	if timestamp.Sub(c.swept) >= time.Minute {
+		c.sweep(timestamp)
+	}
+	state := c.ips[ip]
+	if state == nil {
+		state = &ipState{windowStart: timestamp}
+		c.ips[ip] = state
+	}
+	if timestamp.After(state.lastSeen) {
+		state.lastSeen = timestamp
+	}
+	if timestamp.Sub(state.windowStart) >= time.Minute || timestamp.Before(state.windowStart) {
+		state.windowStart = timestamp
+		state.count = 0
+	}
+	state.count++
+	if state.count > c.rateLimit {
+		state.automated = true
+	}
+	if lowerURI == "/robots.txt" {
+		state.robots = true
+	}
+	if status >= 400 && hasAnyPrefix(lowerURI, probePaths) {
+		state.probes++
+		if state.probes >= probeLimit {
+			state.scanner = true
+		}
+	}
+	return state
+}
+
+// sweep forgets the addresses whose rate window has passed, unless something was found about them,
+// in which case they are kept until they have been idle for forgetAfter; the caller must hold the mutex
+func (c *Classifier) sweep(now time.Time) {
+	for ip, state := range c.ips {
+		idle := now.Sub(state.lastSeen)
+		if idle >= forgetAfter || (idle >= time.Minute && !state.flagged()) {
+			delete(c.ips, ip)
+		}
+	}
+	c.swept = now
+}
+
+// verify returns the class of an address claiming to be a crawler if it has been looked up, and otherwise
+// queues it to be looked up in the background, so that the import is never held up by DNS
+func (c *Classifier) verify(ip string, cr crawler) string {
+	if c.resolver == nil {
+		return Crawler
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if class, ok := c.verified[ip]; ok {
+		return class
+	}
+	if queued, ok := c.queued[ip]; !ok || time.Since(queued) >= retryAfter {
+		if c.resolver.TryEnqueue(cr.token, ip) {
+			c.queued[ip] = time.Now()
+		}
+	}
+	return Crawler
+}
+
+// record checks that an address claiming to be a crawler has a confirmed name in one of the crawler's domains
+func (c *Classifier) record(token string, ip string, result resolver.Result) {
+	class := FakeCrawler
+	name := strings.ToLower(result.Name)
+	for _, cr := range crawlers {
+		if cr.token != token || !result.Confirmed {
+			continue
+		}
+		for _, domain := range cr.domains {
+			if strings.HasSuffix(name, domain) {
+				class = VerifiedCrawler
+				break
+			}
+		}
+	}
+	c.mutex.Lock()
+	c.verified[ip] = class
+	delete(c.queued, ip)
+	c.mutex.Unlock()
+}
+
+func containsAny(s string, words []string) bool {
+	for _, w := range words {
+		if strings.Contains(s, w) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,2 +1,2 @@
 #!/bin/sh
-go build implog.go
+go build -o implog .
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/infodancer/implog/retention"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultInclude matches the files imported from a site's log directory when it has no include patterns
+var DefaultInclude = []string{"*access_log*"}
+
+// Config lists the sites imported in one run and the settings they share
+type Config struct {
+	Store Store `yaml:"store"`
+	// CPU is the number of files imported at once
+	CPU int `yaml:"cpu"`
+	// Rejects is the directory in which to record lines that could not be parsed
+	Rejects string `yaml:"rejects"`
+	// NoRDNS turns off the lookup of the names of new addresses
+	NoRDNS bool `yaml:"no_rdns"`
+	// Resolver is the host:port of the DNS server used for reverse lookups
+	Resolver string `yaml:"resolver"`
+	GeoIP    GeoIP  `yaml:"geoip"`
+	// UAPRegexes is the uap-core regexes.yaml file used to parse new user agents
+	UAPRegexes string `yaml:"uap_regexes"`
+	// BotRate is the number of requests per minute from one address above which its traffic is automated
+	BotRate int    `yaml:"bot_rate"`
+	Sites   []Site `yaml:"sites"`
+}
+
+// Store sets how to connect to the log store. The password is kept out of the connection string,
+// and read from an environment variable or a file instead.
+type Store struct {
+	Driver       string `yaml:"driver"`
+	Connection   string `yaml:"connection"`
+	PasswordEnv  string `yaml:"password_env"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+// GeoIP names the databases used to locate new addresses
+type GeoIP struct {
+	City string `yaml:"city"`
+	ASN  string `yaml:"asn"`
+}
+
+// Site is the profile of one log: where its files are, how to read them and what to store
+type Site struct {
+	Name   string `yaml:"name"`
+	LogDir string `yaml:"logdir"`
+	// Include lists glob patterns matched against the names of the files in LogDir
+	Include   []string `yaml:"include"`
+	LogType   string   `yaml:"logtype"`
+	ParseMode string   `yaml:"parse_mode"`
+	// OwnDomains lists the site's domains, in addition to its name, whose referrals are internal
+	OwnDomains []string      `yaml:"own_domains"`
+	Params     Params        `yaml:"params"`
+	Privacy    Privacy       `yaml:"privacy"`
+	Retention  retention.Age `yaml:"retention"`
+}
+
+// Params lists the query string parameters to store and never to store
+type Params struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// Privacy sets the privacy policy applied to a site's entries
+type Privacy struct {
+	Options []string `yaml:"options"`
+	KeyFile string   `yaml:"key_file"`
+	Scrub   []string `yaml:"scrub"`
+}
+
+// Load reads a configuration file, filling in defaults and checking that every site can be imported
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	result := Config{}
+	err = yaml.Unmarshal(data, &result)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	if result.Store.Driver == "" {
+		result.Store.Driver = "mysql"
+	}
+	if result.Store.PasswordEnv != "" && result.Store.PasswordFile != "" {
+		return nil, fmt.Errorf("%v: use either password_env or password_file, not both", path)
+	}
+	if result.CPU <= 0 {
+		result.CPU = 4
+	}
+	if len(result.Sites) == 0 {
+		return nil, fmt.Errorf("%v: no sites found", path)
+	}
+	names := make(map[string]bool)
+	for i := range result.Sites {
+		site := &result.Sites[i]
+		if site.Name == "" {
+			return nil, fmt.Errorf("%v: site %v has no name", path, i+1)
+		}
+		if names[site.Name] {
+			return nil, fmt.Errorf("%v: site %v is listed twice", path, site.Name)
+		}
+		names[site.Name] = true
+		if site.LogDir == "" {
+			return nil, fmt.Errorf("%v: site %v has no logdir", path, site.Name)
+		}
+		if len(site.Include) == 0 {
+			site.Include = DefaultInclude
+		}
+		for _, pattern := range site.Include {
+			_, err = filepath.Match(pattern, "")
+			if err != nil {
+				return nil, fmt.Errorf("%v: site %v: invalid include pattern %q", path, site.Name, pattern)
+			}
+		}
+		if site.LogType == "" {
+			site.LogType = "http"
+		}
+		if site.ParseMode == "" {
+			site.ParseMode = "lenient"
+		}
+	}
+	return &result, nil
+}
+
+// Password reads the store password from its environment variable or file, or returns an empty string
+// if neither is set
+func (s *Store) Password() (string, error) {
+	if s.PasswordEnv != "" {
+		password, ok := os.LookupEnv(s.PasswordEnv)
+		if !ok {
+			return "", fmt.Errorf("environment variable %v is not set", s.PasswordEnv)
+		}
+		return password, nil
+	}
+	if s.PasswordFile != "" {
+		data, err := os.ReadFile(s.PasswordFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	return "", nil
+}
+
+// Site returns the site with a name, or nil if there is none
+func (c *Config) Site(name string) *Site {
+	for i := range c.Sites {
+		if c.Sites[i].Name == name {
+			return &c.Sites[i]
+		}
+	}
+	return nil
+}
+
+// Matches reports whether a file name matches one of the site's include patterns
+func (s *Site) Matches(name string) bool {
+	for _, pattern := range s.Include {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
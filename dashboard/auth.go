@@ -0,0 +1,115 @@
+package dashboard
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AllLogs in a credential's list of logs grants access to every log
+const AllLogs = "*"
+
+// Credential is a user name and password, or a token, and the logs it may read
+type Credential struct {
+	User     string   `yaml:"user"`
+	Password string   `yaml:"password"`
+	Token    string   `yaml:"token"`
+	Logs     []string `yaml:"logs"`
+}
+
+// Access lists the credentials accepted by the server
+type Access struct {
+	Credentials []Credential `yaml:"credentials"`
+}
+
+// LoadAccess reads the credentials from a YAML file
+func LoadAccess(path string) (*Access, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	result := Access{}
+	err = yaml.Unmarshal(data, &result)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	for i, c := range result.Credentials {
+		if (c.User == "") == (c.Token == "") {
+			return nil, fmt.Errorf("%v: credential %v needs either a user or a token", path, i+1)
+		}
+		if c.User != "" && c.Password == "" {
+			return nil, fmt.Errorf("%v: user %v has no password", path, c.User)
+		}
+		if len(c.Logs) == 0 {
+			return nil, fmt.Errorf("%v: credential %v has no logs; use %q for every log", path, i+1, AllLogs)
+		}
+	}
+	if len(result.Credentials) == 0 {
+		return nil, fmt.Errorf("%v: no credentials found", path)
+	}
+	return &result, nil
+}
+
+var errUnauthorized = errors.New("unauthorized")
+
+// authenticate finds the credential presented with a request, as basic auth or a bearer token
+func (a *Access) authenticate(r *http.Request) (*Credential, error) {
+	if user, password, ok := r.BasicAuth(); ok {
+		for i := range a.Credentials {
+			c := &a.Credentials[i]
+			if c.User != "" && equal(c.User, user) && equal(c.Password, password) {
+				return c, nil
+			}
+		}
+		return nil, errUnauthorized
+	}
+	header := r.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		token := strings.TrimSpace(header[len("Bearer "):])
+		for i := range a.Credentials {
+			c := &a.Credentials[i]
+			if c.Token != "" && equal(c.Token, token) {
+				return c, nil
+			}
+		}
+	}
+	return nil, errUnauthorized
+}
+
+// hasBasic reports whether any credential uses a user name and password, so that browsers should be asked for one
+func (a *Access) hasBasic() bool {
+	for _, c := range a.Credentials {
+		if c.User != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// equal compares secrets in constant time, hashing them first so that their lengths are not revealed either
+func equal(a string, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// allows reports whether a credential may read a log
+func (c *Credential) allows(logname string) bool {
+	for _, l := range c.Logs {
+		if l == AllLogs || l == logname {
+			return true
+		}
+	}
+	return false
+}
+
+// all reports whether a credential may read every log
+func (c *Credential) all() bool {
+	return c.allows(AllLogs)
+}
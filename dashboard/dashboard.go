@@ -0,0 +1,227 @@
+package dashboard
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/infodancer/implog/botclass"
+	"github.com/infodancer/implog/report"
+)
+
+//go:embed static
+var staticFiles embed.FS
+
+// MaxLimit is the largest number of rows a top-N query may ask for
+const MaxLimit = 1000
+
+// Store is the part of a log store the dashboard reads from
+type Store interface {
+	LogNames(ctx context.Context) ([]string, error)
+	Hits(ctx context.Context, filter report.Filter, period string) ([]report.Count, error)
+	Top(ctx context.Context, filter report.Filter, table string, limit int) ([]report.Count, error)
+	Statuses(ctx context.Context, filter report.Filter) ([]report.Count, error)
+}
+
+// Server serves the dashboard and its JSON API, allowing each credential to read only its own logs
+type Server struct {
+	store  Store
+	access *Access
+	mux    *http.ServeMux
+}
+
+// errForbidden is returned when a credential asks for a log it may not read
+var errForbidden = errors.New("access to this log is not allowed")
+
+// New creates a server over a store
+func New(store Store, access *Access) *Server {
+	result := Server{}
+	result.store = store
+	result.access = access
+	result.mux = http.NewServeMux()
+	static, err := fs.Sub(staticFiles, "static")
+	if err != nil {
+		panic(err)
+	}
+	result.mux.Handle("/", http.FileServer(http.FS(static)))
+	result.mux.HandleFunc("/api/logs", result.api(result.logs))
+	result.mux.HandleFunc("/api/hits", result.api(result.hits))
+	result.mux.HandleFunc("/api/top/", result.api(result.top))
+	result.mux.HandleFunc("/api/status", result.api(result.statuses))
+	return &result
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// apiHandler answers an API request from an authenticated credential with a value to be sent as JSON
+type apiHandler func(r *http.Request, c *Credential) (interface{}, error)
+
+// httpError is an error with the status code it should be reported with
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(err error) error {
+	return &httpError{status: http.StatusBadRequest, err: err}
+}
+
+// api wraps an API handler with authentication and JSON encoding
+func (s *Server) api(h apiHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "only GET is supported"})
+			return
+		}
+		c, err := s.access.authenticate(r)
+		if err != nil {
+			if s.access.hasBasic() {
+				w.Header().Set("WWW-Authenticate", `Basic realm="implog"`)
+			}
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			return
+		}
+		result, err := h(r, c)
+		if err != nil {
+			status := http.StatusInternalServerError
+			var he *httpError
+			if errors.As(err, &he) {
+				status = he.status
+			} else if errors.Is(err, errForbidden) {
+				status = http.StatusForbidden
+			} else {
+				log.Printf("error serving %v: %v\n", r.URL.Path, err)
+			}
+			writeJSON(w, status, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// filter reads the name, from, to and class parameters of a request, checking that the credential may read the log.
+// A credential limited to a single log reads that log when no name is given.
+func filter(r *http.Request, c *Credential) (report.Filter, error) {
+	q := r.URL.Query()
+	result := report.Filter{LogName: q.Get("name")}
+	if result.LogName == "" && !c.all() {
+		if len(c.Logs) != 1 {
+			return result, badRequest(errors.New("name is required"))
+		}
+		result.LogName = c.Logs[0]
+	}
+	if result.LogName != "" && !c.allows(result.LogName) {
+		return result, errForbidden
+	}
+	var err error
+	result.From, err = report.ParseTime(q.Get("from"), false)
+	if err != nil {
+		return result, badRequest(err)
+	}
+	result.To, err = report.ParseTime(q.Get("to"), true)
+	if err != nil {
+		return result, badRequest(err)
+	}
+	result.Class = q.Get("class")
+	if result.Class != "" && !botclass.IsClass(result.Class) {
+		return result, badRequest(fmt.Errorf("unknown traffic class: %v", result.Class))
+	}
+	return result, nil
+}
+
+// logList is the answer to /api/logs
+type logList struct {
+	Logs []string `json:"logs"`
+	// All reports whether every log may be read at once, by leaving out the name
+	All bool `json:"all"`
+}
+
+// logs lists the logs the credential may read
+func (s *Server) logs(r *http.Request, c *Credential) (interface{}, error) {
+	names, err := s.store.LogNames(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	allowed := make([]string, 0, len(names))
+	for _, name := range names {
+		if c.allows(name) {
+			allowed = append(allowed, name)
+		}
+	}
+	return logList{Logs: allowed, All: c.all()}, nil
+}
+
+// hits counts requests by the period given in groupBy, by day if there is none
+func (s *Server) hits(r *http.Request, c *Credential) (interface{}, error) {
+	f, err := filter(r, c)
+	if err != nil {
+		return nil, err
+	}
+	period := r.URL.Query().Get("groupBy")
+	if period == "" {
+		period = report.Day
+	}
+	if !contains(report.Periods, period) {
+		return nil, badRequest(errors.New("groupBy must be one of " + strings.Join(report.Periods, ", ")))
+	}
+	return s.store.Hits(r.Context(), f, period)
+}
+
+// top returns the table named in the path, such as /api/top/uris
+func (s *Server) top(r *http.Request, c *Credential) (interface{}, error) {
+	table := strings.TrimPrefix(r.URL.Path, "/api/top/")
+	if !contains(report.Tables, table) {
+		return nil, &httpError{status: http.StatusNotFound, err: errors.New("table must be one of " + strings.Join(report.Tables, ", "))}
+	}
+	f, err := filter(r, c)
+	if err != nil {
+		return nil, err
+	}
+	limit := report.DefaultLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 || limit > MaxLimit {
+			return nil, badRequest(errors.New("limit must be between 1 and " + strconv.Itoa(MaxLimit)))
+		}
+	}
+	return s.store.Top(r.Context(), f, table, limit)
+}
+
+// statuses counts requests by status code
+func (s *Server) statuses(r *http.Request, c *Credential) (interface{}, error) {
+	f, err := filter(r, c)
+	if err != nil {
+		return nil, err
+	}
+	return s.store.Statuses(r.Context(), f)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/infodancer/implog/logstore"
+)
+
+// Export formats
+const (
+	exportCombined = "combined"
+	exportCSV      = "csv"
+	exportJSON     = "json"
+)
+
+// exportColumns are the columns of the CSV export
+var exportColumns = []string{"logname", "time", "address", "ident", "auth", "method", "path", "protocol", "status", "size", "referrer", "user_agent", "traffic_class"}
+
+// export writes the stored entries of a log, or of every log, over a period, for loading elsewhere
+// or for rebuilding log files that have been deleted
+func export(args []string) int {
+	flags := newFlagSet("export", "[-name log] [-from day] [-to day] [-format combined|csv|json] [-out file] [flags]")
+	stores := storeFlags(flags)
+	newFilter := reportFlags(flags)
+	format := flags.String("format", exportCombined, "The output format: combined log lines, csv, or json with one entry per line")
+	out := flags.String("out", "", "The file to write (defaults to standard output)")
+	flags.Parse(args)
+
+	filter, err := newFilter()
+	if err != nil {
+		log.Println(err)
+		return exitUsage
+	}
+	var write func(w io.Writer) func(logstore.Record) error
+	switch *format {
+	case exportCombined:
+		write = writeCombined
+	case exportCSV:
+		write = writeCSV
+	case exportJSON:
+		write = writeJSON
+	default:
+		log.Printf("unknown export format: %v\n", *format)
+		return exitUsage
+	}
+
+	var w io.Writer = os.Stdout
+	if len(*out) > 0 {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Println(err)
+			return exitFailure
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+
+	store, err := stores.openInit(context.Background())
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer store.Close()
+
+	count, err := store.ExportEntries(context.Background(), filter, write(bw))
+	if ferr := bw.Flush(); err == nil {
+		err = ferr
+	}
+	log.Printf("Exported %v entries\n", count)
+	return exitStatus(count, err)
+}
+
+// writeCombined writes records as lines of the combined log format
+func writeCombined(w io.Writer) func(logstore.Record) error {
+	return func(r logstore.Record) error {
+		_, err := fmt.Fprintln(w, r.Combined())
+		return err
+	}
+}
+
+// writeCSV writes records as CSV rows, after a header
+func writeCSV(w io.Writer) func(logstore.Record) error {
+	cw := csv.NewWriter(w)
+	// An error writing the header is kept by the csv writer and returned with the first row
+	cw.Write(exportColumns)
+	cw.Flush()
+	return func(r logstore.Record) error {
+		t := ""
+		if !r.Time.IsZero() {
+			t = r.Time.UTC().Format(time.RFC3339)
+		}
+		err := cw.Write([]string{r.LogName, t, r.Address, r.Ident, r.Auth, r.Method, r.Path, r.Protocol,
+			strconv.FormatInt(r.Status, 10), strconv.FormatInt(r.Size, 10), r.Referrer, r.UserAgent, r.TrafficClass})
+		if err != nil {
+			return err
+		}
+		// Flushed on every row, since the buffered writer beneath batches the output anyway
+		cw.Flush()
+		return cw.Error()
+	}
+}
+
+// writeJSON writes records as JSON objects, one per line
+func writeJSON(w io.Writer) func(logstore.Record) error {
+	enc := json.NewEncoder(w)
+	return func(r logstore.Record) error {
+		return enc.Encode(r)
+	}
+}
@@ -0,0 +1,114 @@
+package geoip
+
+import (
+	"errors"
+	"net/netip"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Location describes where an address is and which network it belongs to.
+// Fields are left empty when the databases have nothing for the address.
+type Location struct {
+	Country      string
+	Region       string
+	City         string
+	Latitude     float64
+	Longitude    float64
+	HasPosition  bool
+	ASN          uint
+	Organization string
+}
+
+// DB looks up addresses in local GeoLite2 or DB-IP databases in MaxMind's .mmdb format
+type DB struct {
+	city *maxminddb.Reader
+	asn  *maxminddb.Reader
+}
+
+type cityRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Location struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+type asnRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// Open opens a city (or country) database and an ASN database; either path may be empty, but not both
+func Open(cityPath string, asnPath string) (*DB, error) {
+	if cityPath == "" && asnPath == "" {
+		return nil, errors.New("no geoip database specified")
+	}
+	result := DB{}
+	var err error
+	if cityPath != "" {
+		result.city, err = maxminddb.Open(cityPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if asnPath != "" {
+		result.asn, err = maxminddb.Open(asnPath)
+		if err != nil {
+			result.Close()
+			return nil, err
+		}
+	}
+	return &result, nil
+}
+
+// Lookup finds the location and network of an address
+func (d *DB) Lookup(addr netip.Addr) (Location, error) {
+	result := Location{}
+	ip := addr.Unmap().AsSlice()
+	if d.city != nil {
+		var record cityRecord
+		err := d.city.Lookup(ip, &record)
+		if err != nil {
+			return result, err
+		}
+		result.Country = record.Country.ISOCode
+		if len(record.Subdivisions) > 0 {
+			result.Region = record.Subdivisions[0].Names["en"]
+		}
+		result.City = record.City.Names["en"]
+		if record.Location.Latitude != nil && record.Location.Longitude != nil {
+			result.Latitude = *record.Location.Latitude
+			result.Longitude = *record.Location.Longitude
+			result.HasPosition = true
+		}
+	}
+	if d.asn != nil {
+		var record asnRecord
+		err := d.asn.Lookup(ip, &record)
+		if err != nil {
+			return result, err
+		}
+		result.ASN = record.Number
+		result.Organization = record.Organization
+	}
+	return result, nil
+}
+
+// Close closes the databases
+func (d *DB) Close() {
+	if d.city != nil {
+		d.city.Close()
+	}
+	if d.asn != nil {
+		d.asn.Close()
+	}
+}
@@ -0,0 +1,234 @@
+package hll
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// DefaultPrecision gives 4096 registers, for a standard error of about 1.6%
+const DefaultPrecision = 12
+
+// MinPrecision and MaxPrecision bound the number of index bits
+const (
+	MinPrecision = 4
+	MaxPrecision = 16
+)
+
+const version = 1
+
+// Encodings of a serialized sketch
+const (
+	sparseEncoding = 0
+	denseEncoding  = 1
+)
+
+// Sketch is a HyperLogLog sketch, estimating the number of distinct values added to it.
+// Small sketches keep only their non-zero registers, so that sketches of rarely seen keys stay small.
+type Sketch struct {
+	p         uint8
+	registers []uint8
+	sparse    map[uint16]uint8
+}
+
+// New creates an empty sketch with 2^p registers
+func New(p uint8) (*Sketch, error) {
+	if p < MinPrecision || p > MaxPrecision {
+		return nil, fmt.Errorf("precision must be between %v and %v", MinPrecision, MaxPrecision)
+	}
+	result := Sketch{}
+	result.p = p
+	result.sparse = make(map[uint16]uint8)
+	return &result, nil
+}
+
+// NewDefault creates an empty sketch with the default precision
+func NewDefault() *Sketch {
+	s, _ := New(DefaultPrecision)
+	return s
+}
+
+// Precision reports the number of index bits of the sketch
+func (s *Sketch) Precision() uint8 {
+	return s.p
+}
+
+func (s *Sketch) size() int {
+	return 1 << s.p
+}
+
+// hash spreads the bits of FNV-1a with the finalizer from MurmurHash3, since HyperLogLog needs well mixed bits
+func hash(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// Add adds a value to the sketch
+func (s *Sketch) Add(data []byte) {
+	h := hash(data)
+	index := uint16(h >> (64 - s.p))
+	// The guard bit limits the run of zeros to the bits that are left
+	w := h<<s.p | 1<<(s.p-1)
+	s.set(index, uint8(bits.LeadingZeros64(w)+1))
+}
+
+// AddString adds a string value to the sketch
+func (s *Sketch) AddString(v string) {
+	s.Add([]byte(v))
+}
+
+// set raises a register to a value, if it is lower
+func (s *Sketch) set(index uint16, value uint8) {
+	if s.registers != nil {
+		if value > s.registers[index] {
+			s.registers[index] = value
+		}
+		return
+	}
+	if value > s.sparse[index] {
+		s.sparse[index] = value
+		// Beyond a quarter of the registers, the map takes more room than the dense form
+		if len(s.sparse) > s.size()/4 {
+			s.densify()
+		}
+	}
+}
+
+func (s *Sketch) densify() {
+	s.registers = make([]uint8, s.size())
+	for i, v := range s.sparse {
+		s.registers[i] = v
+	}
+	s.sparse = nil
+}
+
+// Merge adds the values of another sketch of the same precision to this one
+func (s *Sketch) Merge(o *Sketch) error {
+	if o.p != s.p {
+		return errors.New("cannot merge sketches of different precision")
+	}
+	if o.registers != nil {
+		for i, v := range o.registers {
+			if v > 0 {
+				s.set(uint16(i), v)
+			}
+		}
+		return nil
+	}
+	for i, v := range o.sparse {
+		s.set(i, v)
+	}
+	return nil
+}
+
+// Estimate returns the estimated number of distinct values added to the sketch
+func (s *Sketch) Estimate() uint64 {
+	m := float64(s.size())
+	sum := 0.0
+	zeros := 0
+	if s.registers != nil {
+		for _, v := range s.registers {
+			sum += math.Ldexp(1, -int(v))
+			if v == 0 {
+				zeros++
+			}
+		}
+	} else {
+		zeros = s.size() - len(s.sparse)
+		sum = float64(zeros)
+		for _, v := range s.sparse {
+			sum += math.Ldexp(1, -int(v))
+		}
+	}
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	// Linear counting is more accurate while many registers are still empty
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+// MarshalBinary encodes the sketch, listing only the non-zero registers if that is smaller
+func (s *Sketch) MarshalBinary() ([]byte, error) {
+	nonzero := make([]uint16, 0)
+	if s.registers != nil {
+		for i, v := range s.registers {
+			if v > 0 {
+				nonzero = append(nonzero, uint16(i))
+			}
+		}
+	} else {
+		for i := range s.sparse {
+			nonzero = append(nonzero, i)
+		}
+	}
+	if 3*len(nonzero) < s.size() {
+		result := make([]byte, 3, 3+3*len(nonzero))
+		result[0], result[1], result[2] = version, s.p, sparseEncoding
+		for _, i := range nonzero {
+			result = binary.BigEndian.AppendUint16(result, i)
+			result = append(result, s.get(i))
+		}
+		return result, nil
+	}
+	result := make([]byte, 3, 3+s.size())
+	result[0], result[1], result[2] = version, s.p, denseEncoding
+	for i := 0; i < s.size(); i++ {
+		result = append(result, s.get(uint16(i)))
+	}
+	return result, nil
+}
+
+func (s *Sketch) get(index uint16) uint8 {
+	if s.registers != nil {
+		return s.registers[index]
+	}
+	return s.sparse[index]
+}
+
+// UnmarshalBinary decodes a sketch encoded by MarshalBinary, replacing the contents of this one
+func (s *Sketch) UnmarshalBinary(data []byte) error {
+	if len(data) < 3 || data[0] != version {
+		return errors.New("not a sketch")
+	}
+	decoded, err := New(data[1])
+	if err != nil {
+		return err
+	}
+	body := data[3:]
+	switch data[2] {
+	case sparseEncoding:
+		if len(body)%3 != 0 {
+			return errors.New("truncated sketch")
+		}
+		for i := 0; i < len(body); i += 3 {
+			index := binary.BigEndian.Uint16(body[i:])
+			if int(index) >= decoded.size() {
+				return errors.New("sketch register out of range")
+			}
+			decoded.set(index, body[i+2])
+		}
+	case denseEncoding:
+		if len(body) != decoded.size() {
+			return errors.New("truncated sketch")
+		}
+		decoded.registers = make([]uint8, decoded.size())
+		copy(decoded.registers, body)
+		decoded.sparse = nil
+	default:
+		return errors.New("unknown sketch encoding")
+	}
+	*s = *decoded
+	return nil
+}
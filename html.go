@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/infodancer/implog/htmlreport"
+	"github.com/infodancer/implog/report"
+)
+
+// generateHTML writes a static HTML site of monthly reports for a log, regenerating only the months that have changed
+func generateHTML(args []string) int {
+	flags := newFlagSet("html", "-out dir [-name log] [-title title] [flags]")
+	stores := storeFlags(flags)
+	logname := flags.String("name", "", "The name of the log to report on (defaults to every log)")
+	out := flags.String("out", "", "The directory in which to write the site")
+	title := flags.String("title", "", "The title of the site (defaults to the log name)")
+	limit := flags.Int("limit", 25, "The number of rows in each top-N table")
+	flags.Parse(args)
+
+	if len(*out) == 0 {
+		log.Println("an output directory must be specified with -out")
+		return exitUsage
+	}
+	if len(*title) == 0 {
+		*title = *logname
+		if len(*title) == 0 {
+			*title = "All logs"
+		}
+	}
+
+	store, err := stores.open()
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	err = store.Init(context.Background())
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	months, err := store.Hits(ctx, report.Filter{LogName: *logname}, report.Month)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	count, err := htmlreport.Generate(*out, *title, months, func(month string) (*report.Report, error) {
+		filter, err := report.MonthFilter(*logname, month)
+		if err != nil {
+			return nil, err
+		}
+		return store.Report(ctx, filter, *limit)
+	})
+	log.Printf("Generated %v of %v months\n", count, len(months))
+	return exitStatus(count, err)
+}
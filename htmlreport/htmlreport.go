@@ -0,0 +1,200 @@
+package htmlreport
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/infodancer/implog/report"
+)
+
+//go:embed templates/*.html
+var templateFiles embed.FS
+
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"bytes": formatBytes,
+}).ParseFS(templateFiles, "templates/*.html"))
+
+// stateFile records what each month's page was generated from, so unchanged months are not generated again
+const stateFile = "implog-html.json"
+
+type state struct {
+	Months map[string]report.Count `json:"months"`
+}
+
+// Loader gathers the report for a calendar month, given as YYYY-MM
+type Loader func(month string) (*report.Report, error)
+
+type indexPage struct {
+	Title     string
+	Generated time.Time
+	Chart     template.HTML
+	Months    []report.Count
+	Total     report.Count
+}
+
+type monthPage struct {
+	Title     string
+	Month     string
+	Generated time.Time
+	Total     report.Count
+	Previous  string
+	Next      string
+	Daily     template.HTML
+	Hourly    template.HTML
+	Sections  []report.Section
+}
+
+// Generate writes a static site into a directory: an index with a chart of every month and a page for each month.
+// Only the pages of months whose totals have changed since the last run, or that are missing, are written again,
+// and the number of month pages written is returned.
+func Generate(dir string, title string, months []report.Count, load Loader) (int, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return 0, err
+	}
+	st := readState(dir)
+
+	// A month's page is written again if its totals have changed or it is missing,
+	// and so is the page before a new month, so that it links to it
+	stale := make([]bool, len(months))
+	for i, m := range months {
+		prev, ok := st.Months[m.Key]
+		if !ok && i > 0 {
+			stale[i-1] = true
+		}
+		if !ok || prev != m || !exists(filepath.Join(dir, m.Key+".html")) {
+			stale[i] = true
+		}
+	}
+
+	count := 0
+	for i, m := range months {
+		if !stale[i] {
+			continue
+		}
+		r, err := load(m.Key)
+		if err != nil {
+			return count, err
+		}
+		data, err := newMonthPage(title, m, r)
+		if err != nil {
+			return count, err
+		}
+		if i > 0 {
+			data.Previous = months[i-1].Key
+		}
+		if i < len(months)-1 {
+			data.Next = months[i+1].Key
+		}
+		err = writePage(filepath.Join(dir, m.Key+".html"), "month.html", data)
+		if err != nil {
+			return count, err
+		}
+		st.Months[m.Key] = m
+		count++
+	}
+
+	index := indexPage{Title: title, Generated: time.Now().UTC(), Chart: barChart(months)}
+	for i := len(months) - 1; i >= 0; i-- {
+		index.Months = append(index.Months, months[i])
+		index.Total.Hits += months[i].Hits
+		index.Total.Bytes += months[i].Bytes
+	}
+	err = writePage(filepath.Join(dir, "index.html"), "index.html", index)
+	if err != nil {
+		return count, err
+	}
+	return count, writeState(dir, st)
+}
+
+func newMonthPage(title string, m report.Count, r *report.Report) (monthPage, error) {
+	start, err := time.Parse("2006-01", m.Key)
+	if err != nil {
+		return monthPage{}, fmt.Errorf("invalid month %q", m.Key)
+	}
+	result := monthPage{Title: title, Month: m.Key, Generated: time.Now().UTC(), Total: m}
+
+	// Every day and hour gets a bar, even those without requests
+	byDay := make(map[string]report.Count)
+	for _, c := range r.Bandwidth {
+		byDay[c.Key] = c
+	}
+	days := make([]report.Count, 0)
+	for d := start; d.Before(start.AddDate(0, 1, 0)); d = d.AddDate(0, 0, 1) {
+		c := byDay[d.Format("2006-01-02")]
+		c.Key = fmt.Sprint(d.Day())
+		days = append(days, c)
+	}
+	result.Daily = barChart(days)
+
+	byHour := make(map[string]report.Count)
+	for _, c := range r.Hours {
+		byHour[c.Key] = c
+	}
+	hours := make([]report.Count, 0, 24)
+	for h := 0; h < 24; h++ {
+		key := fmt.Sprintf("%02d", h)
+		c := byHour[key]
+		c.Key = key
+		hours = append(hours, c)
+	}
+	result.Hourly = barChart(hours)
+
+	for _, section := range r.Sections() {
+		if len(section.Rows) > 0 {
+			result.Sections = append(result.Sections, section)
+		}
+	}
+	return result, nil
+}
+
+// writePage renders a template to a file, replacing it only once it is complete
+func writePage(path string, name string, data interface{}) error {
+	var buf bytes.Buffer
+	err := templates.ExecuteTemplate(&buf, name, data)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, buf.Bytes())
+}
+
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	err := os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// readState reads the record of the last run, starting afresh if there is none or it cannot be read
+func readState(dir string) state {
+	st := state{}
+	data, err := os.ReadFile(filepath.Join(dir, stateFile))
+	if err == nil {
+		json.Unmarshal(data, &st)
+	}
+	if st.Months == nil {
+		st.Months = make(map[string]report.Count)
+	}
+	return st
+}
+
+func writeState(dir string, st state) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(dir, stateFile), data)
+}
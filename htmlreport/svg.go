@@ -0,0 +1,74 @@
+package htmlreport
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"strings"
+
+	"github.com/infodancer/implog/report"
+)
+
+// Chart dimensions, in SVG user units
+const (
+	chartWidth  = 720
+	chartHeight = 200
+	chartTop    = 10
+	chartBottom = 30
+	chartLeft   = 60
+)
+
+// barChart draws the hits of each count as a bar, labelled with its key, as inline SVG.
+// Labels are thinned out when there are too many bars for them all to be readable.
+func barChart(counts []report.Count) template.HTML {
+	var max int64
+	for _, c := range counts {
+		if c.Hits > max {
+			max = c.Hits
+		}
+	}
+	plotWidth := float64(chartWidth - chartLeft)
+	plotHeight := float64(chartHeight - chartTop - chartBottom)
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg class="chart" viewBox="0 0 %d %d" width="100%%" role="img" xmlns="http://www.w3.org/2000/svg">`, chartWidth, chartHeight)
+	fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" class="axis"/>`, chartLeft, chartHeight-chartBottom, chartWidth, chartHeight-chartBottom)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" class="scale">%d</text>`, chartLeft-6, chartTop+10, max)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" class="scale">0</text>`, chartLeft-6, chartHeight-chartBottom)
+	if len(counts) > 0 {
+		slot := plotWidth / float64(len(counts))
+		labelEvery := 1
+		for float64(labelEvery)*slot < 28 {
+			labelEvery++
+		}
+		for i, c := range counts {
+			h := 0.0
+			if max > 0 {
+				h = plotHeight * float64(c.Hits) / float64(max)
+			}
+			x := float64(chartLeft) + float64(i)*slot
+			y := float64(chartHeight-chartBottom) - h
+			fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" class="bar"><title>%s: %d hits, %s</title></rect>`,
+				x+slot*0.1, y, slot*0.8, h, html.EscapeString(c.Key), c.Hits, formatBytes(c.Bytes))
+			if i%labelEvery == 0 {
+				fmt.Fprintf(&b, `<text x="%.1f" y="%d" class="label">%s</text>`, x+slot/2, chartHeight-chartBottom+16, html.EscapeString(c.Key))
+			}
+		}
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// formatBytes shows a number of bytes in the largest unit that keeps it at or above one
+func formatBytes(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB", "PB"}
+	value := float64(n)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+	return fmt.Sprintf("%.1f %v", value, units[i])
+}
@@ -0,0 +1,27 @@
+package httplog
+
+import "fmt"
+
+// ParseError reports where and why a log line could not be parsed
+type ParseError struct {
+	// Column is the 1-based byte offset in the line at which the problem was found
+	Column int
+	// Field names the field being parsed, if the line was split into fields successfully
+	Field string
+	// Reason is a short description of the problem, suitable for grouping similar failures
+	Reason string
+	// Err is the underlying error, if any
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	msg := fmt.Sprintf("column %d: %s", e.Column, e.Reason)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
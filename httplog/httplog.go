@@ -2,8 +2,7 @@ package httplog
 
 import (
 	"crypto/sha1"
-	"errors"
-	"strconv"
+	"encoding/base64"
 	"strings"
 	"time"
 )
@@ -11,11 +10,15 @@ import (
 // EntryData represents a standard HTTP log format
 type EntryData struct {
 	UUID            []byte
+	legacyPrefix    string
 	isParseError    bool
+	badFields       []string
 	logtype         string
 	logfile         string
 	logname         string
 	logfileModified time.Time
+	trafficClass    string
+	fields          int
 	IPAddress       string
 	ClientIdent     string
 	ClientAuth      string
@@ -26,28 +29,38 @@ type EntryData struct {
 	Referrer        string
 	RequestMethod   string
 	RequestURI      string
+	RequestPath     string
 	RequestProtocol string
 	RequestParams   string
 	ClientVersion   string
+	SessionCookie   string
 }
 
 // Entry defines the interface for HTTP log entries
 type Entry interface {
 	IsParseError() bool
+	GetBadFields() []string
 	GetLogName() string
 	GetLogType() string
 	GetLogFile() string
 	GetLogFileModified() time.Time
 	SetLogFile(file string)
 	SetLogFileModified(modified time.Time)
+	GetTrafficClass() string
+	SetTrafficClass(class string)
 	GetUUID() []byte
+	GetLegacyUUID() []byte
 	GetIPAddress() string
 	GetClientIdent() string
 	GetClientAuth() string
 	GetClientVersion() string
+	GetSessionCookie() string
+	GetTimestamp() time.Time
 	GetRequestMethod() string
 	GetRequestProtocol() string
 	GetRequestURI() string
+	GetRequestPath() string
+	GetRequestParams() string
 	GetStatus() int64
 	GetSize() int64
 	GetReferrer() string
@@ -57,6 +70,11 @@ func (e *EntryData) IsParseError() bool {
 	return e.isParseError
 }
 
+// GetBadFields reports the fields that failed validation when the entry was parsed leniently
+func (e *EntryData) GetBadFields() []string {
+	return e.badFields
+}
+
 func (e *EntryData) GetLogType() string {
 	return e.logtype
 }
@@ -85,10 +103,27 @@ func (e *EntryData) SetLogName(name string) {
 	e.logname = name
 }
 
+// GetTrafficClass reports whether the entry came from a person, a crawler, a scanner and so on
+func (e *EntryData) GetTrafficClass() string {
+	return e.trafficClass
+}
+
+func (e *EntryData) SetTrafficClass(class string) {
+	e.trafficClass = class
+}
+
 func (e *EntryData) GetUUID() []byte {
 	return e.UUID
 }
 
+// GetLegacyUUID returns the id that earlier versions gave the line, or nil if the entry was not parsed from one
+func (e *EntryData) GetLegacyUUID() []byte {
+	if e.legacyPrefix == "" {
+		return nil
+	}
+	return []byte(base64.URLEncoding.EncodeToString([]byte(e.legacyPrefix)))
+}
+
 func (e *EntryData) GetIPAddress() string {
 	return e.IPAddress
 }
@@ -105,6 +140,15 @@ func (e *EntryData) GetClientVersion() string {
 	return e.ClientVersion
 }
 
+// GetSessionCookie returns the session cookie logged after the user agent, if the log format includes one
+func (e *EntryData) GetSessionCookie() string {
+	return e.SessionCookie
+}
+
+func (e *EntryData) GetTimestamp() time.Time {
+	return e.Timestamp
+}
+
 func (e *EntryData) GetRequestMethod() string {
 	return e.RequestMethod
 }
@@ -117,6 +161,16 @@ func (e *EntryData) GetRequestURI() string {
 	return e.RequestURI
 }
 
+// GetRequestPath returns the requested URI without its query string
+func (e *EntryData) GetRequestPath() string {
+	return e.RequestPath
+}
+
+// GetRequestParams returns the query string of the requested URI, without the leading ?
+func (e *EntryData) GetRequestParams() string {
+	return e.RequestParams
+}
+
 func (e *EntryData) GetStatus() int64 {
 	return e.Status
 }
@@ -129,21 +183,80 @@ func (e *EntryData) GetReferrer() string {
 	return e.Referrer
 }
 
+// Formats of HTTP log lines, told apart by the number of fields
+const (
+	FormatCommon   = "common"
+	FormatCombined = "combined"
+	// FormatCookie is the combined format with a session cookie logged after the user agent
+	FormatCookie = "combined+cookie"
+	// FormatOther is any other number of fields, such as a common format line missing its size
+	FormatOther = "other"
+)
+
+// Format reports the format of the line the entry was parsed from
+func (e *EntryData) Format() string {
+	switch {
+	case e.fields == requiredFields:
+		return FormatCommon
+	case e.fields == requiredFields+2:
+		return FormatCombined
+	case e.fields > requiredFields+2:
+		return FormatCookie
+	}
+	return FormatOther
+}
+
+// legacyPrefix returns the part of a line from which earlier versions derived its id, so that entries stored
+// by them can still be recognized. They took sha1.New().Sum(line), which appends the hash of nothing to the line
+// instead of hashing it, and stored its base64url encoding in a 16 byte column. Those 16 characters encode
+// the first 12 bytes, which for any real line are the start of the line itself.
+func legacyPrefix(line string) string {
+	if len(line) >= 12 {
+		return line[:12]
+	}
+	empty := sha1.Sum(nil)
+	return (line + string(empty[:]))[:12]
+}
+
+// ParseLogLine parses a single line in common or combined log format, leniently.
+// Lines that cannot be parsed are reported with a *ParseError.
 func ParseLogLine(line string) (*EntryData, error) {
+	return ParseLogLineMode(line, Lenient)
+}
+
+// ParseLogLineMode parses a single line in common or combined log format.
+// In strict mode any field that fails validation causes the line to be rejected with a *ParseError;
+// in lenient mode the line is accepted but marked as a parse error, and the invalid fields are recorded.
+// Lines whose quotes or brackets cannot be matched are rejected in either mode.
+func ParseLogLineMode(line string, mode ParseMode) (*EntryData, error) {
 	result := EntryData{}
 	result.isParseError = true
-	// Hash the line for UUID to avoid duplicates
-	bytes := []byte(line)
-	hasher := sha1.New()
-	result.UUID = hasher.Sum(bytes)
-
-	words, err := parseEntryWords(line)
+	// Hash the line so that a line read twice is recognized as a duplicate
+	hash := sha1.Sum([]byte(line))
+	result.UUID = hash[:]
+	result.legacyPrefix = legacyPrefix(line)
+
+	t := tokenizerPool.Get().(*tokenizer)
+	defer tokenizerPool.Put(t)
+	words, err := t.split(line)
 	if err != nil {
 		return nil, err
 	}
-	result.isParseError = true
+	for i := len(words); i < requiredFields; i++ {
+		err = result.invalid(mode, fieldNames[i], len(line)+1, "missing "+fieldNames[i], nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if len(words) >= 1 {
 		result.IPAddress = words[0]
+		if !validIPAddress(words[0]) {
+			err = result.invalid(mode, fieldIPAddress, t.column(0), "invalid ip address", nil)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 	if len(words) >= 2 {
 		result.ClientIdent = words[1]
@@ -154,35 +267,50 @@ func ParseLogLine(line string) (*EntryData, error) {
 	if len(words) >= 5 {
 		result.Timestamp, err = parseHTTPTimestamp(words[3])
 		if err != nil {
-			return nil, err
-		}
-	}
-	if len(words) >= 5 {
-		result.RequestMethod, err = parseRequestMethod(words[4])
-		if err != nil {
-			return nil, err
-		}
-
-		result.RequestURI, err = parseRequestURI(words[4])
-		if err != nil {
-			return nil, err
-		}
-
-		result.RequestParams, err = parseRequestParams(words[4])
-		if err != nil {
-			return nil, err
+			err = result.invalid(mode, fieldTimestamp, t.column(3), "invalid timestamp", err)
+			if err != nil {
+				return nil, err
+			}
 		}
-
-		result.RequestProtocol, err = parseRequestProtocol(words[4])
-		if err != nil {
-			return nil, err
+		if pe := result.parseRequest(words[4], t.column(4)); pe != nil {
+			err = result.invalid(mode, fieldRequest, pe.Column, pe.Reason, nil)
+			if err != nil {
+				return nil, err
+			}
+		} else if words[4] != "-" {
+			if !validMethod(result.RequestMethod) {
+				err = result.invalid(mode, fieldMethod, t.column(4), "invalid request method", nil)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if !validProtocol(result.RequestProtocol) {
+				err = result.invalid(mode, fieldProtocol, t.column(4), "invalid request protocol", nil)
+				if err != nil {
+					return nil, err
+				}
+			}
 		}
 	}
 	if len(words) >= 6 {
-		result.Status, err = strconv.ParseInt(words[5], 0, 64)
+		var ok bool
+		result.Status, ok = parseStatus(words[5])
+		if !ok {
+			err = result.invalid(mode, fieldStatus, t.column(5), "invalid status", nil)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 	if len(words) >= 7 {
-		result.Size, err = strconv.ParseInt(words[6], 0, 64)
+		var ok bool
+		result.Size, ok = parseSize(words[6])
+		if !ok {
+			err = result.invalid(mode, fieldSize, t.column(6), "invalid size", nil)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 	if len(words) >= 8 {
 		result.Referrer = words[7]
@@ -190,97 +318,71 @@ func ParseLogLine(line string) (*EntryData, error) {
 	if len(words) >= 9 {
 		result.ClientVersion = words[8]
 	}
-	result.isParseError = false
+	// A custom format may log a session cookie after the user agent, for instance with "%{SESSIONID}C"
+	if len(words) >= 10 && words[9] != "-" {
+		result.SessionCookie = words[9]
+	}
+	result.isParseError = len(result.badFields) > 0
 	result.logtype = "HTTP"
+	result.fields = len(words)
 	return &result, nil
 }
 
+// invalid handles a field that failed validation according to the parse mode,
+// returning a *ParseError in strict mode and recording the field in lenient mode
+func (e *EntryData) invalid(mode ParseMode, field string, column int, reason string, err error) error {
+	if mode == Strict {
+		return &ParseError{Column: column, Field: field, Reason: reason, Err: err}
+	}
+	e.badFields = append(e.badFields, field)
+	return nil
+}
+
 func parseHTTPTimestamp(word string) (time.Time, error) {
 	return time.Parse("_2/Jan/2006:15:04:05 -0700", word)
 }
 
-func parseRequestMethod(request string) (string, error) {
-	words, err := parseEntryWords(request)
-	if err != nil {
-		return "", err
+// parseRequest splits the request line into method, URI, path, parameters and protocol in a single pass.
+// A request of "-", which Apache logs when no request was received (as with a 408), leaves them all empty.
+// The column is where the request starts within the log line, and is used for reporting errors.
+func (e *EntryData) parseRequest(request string, column int) *ParseError {
+	if request == "-" {
+		return nil
 	}
-	if len(words) >= 1 {
-		return words[0], nil
+	method, rest := nextRequestWord(request)
+	if method == "" {
+		return &ParseError{Column: column, Field: "request", Reason: "request method not specified"}
 	}
-	return "", errors.New("request method not specified")
-}
-
-func parseRequestURI(request string) (string, error) {
-	words, err := parseEntryWords(request)
-	if err != nil {
-		return "", err
+	rest = strings.Trim(rest, " ")
+	if rest == "" {
+		return &ParseError{Column: column + len(request), Field: "request", Reason: "request URI not specified"}
 	}
-	if len(words) >= 2 {
-		return words[1], nil
-	}
-	return "", errors.New("request protocol not specified")
-}
-
-func parseRequestParams(request string) (string, error) {
-	words, err := parseEntryWords(request)
-	if err != nil {
-		return "", err
+	// URIs may contain unescaped spaces, so the protocol is taken from the end of the request
+	i := strings.LastIndexByte(rest, ' ')
+	if i < 0 {
+		return &ParseError{Column: column + len(request), Field: "request", Reason: "request protocol not specified"}
 	}
-	if len(words) >= 2 {
-		uri := strings.Split(words[1], "?")
-		if len(uri) > 1 {
-			return uri[1], nil
+	uri, protocol := strings.TrimRight(rest[:i], " "), rest[i+1:]
+	e.RequestMethod = method
+	e.RequestURI = uri
+	e.RequestPath = uri
+	e.RequestProtocol = protocol
+	if q := strings.IndexByte(uri, '?'); q >= 0 {
+		e.RequestPath = uri[:q]
+		params := uri[q+1:]
+		if j := strings.IndexByte(params, '?'); j >= 0 {
+			params = params[:j]
 		}
+		e.RequestParams = params
 	}
-	return "", nil
+	return nil
 }
 
-func parseRequestProtocol(request string) (string, error) {
-	words, err := parseEntryWords(request)
-	if err != nil {
-		return "", err
-	}
-	if len(words) >= 3 {
-		return words[2], nil
-	}
-	return "", errors.New("request protocol not specified")
-}
-
-func parseEntryWords(line string) ([]string, error) {
-	words := make([]string, 0)
-	var word strings.Builder
-	quoted := false
-	for _, c := range line {
-		s := string(c)
-		if s == "\"" {
-			if quoted {
-				quoted = false
-				words = append(words, word.String())
-				word.Reset()
-			} else {
-				quoted = true
-			}
-		} else if s == "[" || s == "]" {
-			if quoted {
-				quoted = false
-				words = append(words, word.String())
-				word.Reset()
-			} else {
-				quoted = true
-			}
-		} else if s == " " && !quoted {
-			if word.Len() > 0 {
-				v := strings.TrimSpace(word.String())
-				words = append(words, v)
-			}
-			word.Reset()
-		} else {
-			word.WriteString(s)
-		}
-	}
-	if word.Len() > 0 {
-		v := strings.TrimSpace(word.String())
-		words = append(words, v)
+// nextRequestWord returns the next space separated word in a request line and the remainder of the line
+func nextRequestWord(s string) (string, string) {
+	s = strings.TrimLeft(s, " ")
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		return s[:i], s[i:]
 	}
-	return words, nil
+	return s, ""
 }
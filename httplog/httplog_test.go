@@ -0,0 +1,166 @@
+package httplog
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+// fixtures are lines in the formats Apache writes, with the fields they should be parsed into
+var fixtures = []struct {
+	name   string
+	line   string
+	want   EntryData
+	format string
+}{
+	{
+		name: "common",
+		line: `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`,
+		want: EntryData{
+			IPAddress: "127.0.0.1", ClientIdent: "-", ClientAuth: "frank",
+			RequestMethod: "GET", RequestURI: "/apache_pb.gif", RequestPath: "/apache_pb.gif", RequestProtocol: "HTTP/1.0",
+			Status: 200, Size: 2326,
+		},
+		format: FormatCommon,
+	},
+	{
+		name: "combined",
+		line: `192.168.1.20 - - [10/Oct/2000:13:55:36 -0700] "GET /search?q=go&page=2 HTTP/1.1" 200 512 "http://www.example.com/start.html" "Mozilla/4.08 [en] (Win98; I ;Nav)"`,
+		want: EntryData{
+			IPAddress: "192.168.1.20", ClientIdent: "-", ClientAuth: "-",
+			RequestMethod: "GET", RequestURI: "/search?q=go&page=2", RequestPath: "/search", RequestParams: "q=go&page=2", RequestProtocol: "HTTP/1.1",
+			Status: 200, Size: 512, Referrer: "http://www.example.com/start.html", ClientVersion: "Mozilla/4.08 [en] (Win98; I ;Nav)",
+		},
+		format: FormatCombined,
+	},
+	{
+		name: "escaped quotes",
+		line: `10.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /a\"b HTTP/1.1" 404 - "-" "say \"hi\""`,
+		want: EntryData{
+			IPAddress: "10.0.0.1", ClientIdent: "-", ClientAuth: "-",
+			RequestMethod: "GET", RequestURI: `/a"b`, RequestPath: `/a"b`, RequestProtocol: "HTTP/1.1",
+			Status: 404, Size: 0, Referrer: "-", ClientVersion: `say "hi"`,
+		},
+		format: FormatCombined,
+	},
+	{
+		name: "no request",
+		line: `10.0.0.2 - - [10/Oct/2000:13:55:36 -0700] "-" 408 -`,
+		want: EntryData{
+			IPAddress: "10.0.0.2", ClientIdent: "-", ClientAuth: "-",
+			Status: 408,
+		},
+		format: FormatCommon,
+	},
+	{
+		name: "spaces in the uri",
+		line: `2001:db8::1 - - [10/Oct/2000:13:55:36 -0700] "GET /my file.html?a=b c HTTP/1.1" 200 10 "-" "curl/8.0"`,
+		want: EntryData{
+			IPAddress: "2001:db8::1", ClientIdent: "-", ClientAuth: "-",
+			RequestMethod: "GET", RequestURI: "/my file.html?a=b c", RequestPath: "/my file.html", RequestParams: "a=b c", RequestProtocol: "HTTP/1.1",
+			Status: 200, Size: 10, Referrer: "-", ClientVersion: "curl/8.0",
+		},
+		format: FormatCombined,
+	},
+}
+
+func TestParseLogLine(t *testing.T) {
+	timestamp := time.Date(2000, time.October, 10, 13, 55, 36, 0, time.FixedZone("", -7*60*60))
+	for _, f := range fixtures {
+		t.Run(f.name, func(t *testing.T) {
+			got, err := ParseLogLine(f.line)
+			if err != nil {
+				t.Fatalf("ParseLogLine: %v", err)
+			}
+			if got.IsParseError() {
+				t.Errorf("marked as a parse error, with bad fields %v", got.GetBadFields())
+			}
+			if !got.Timestamp.Equal(timestamp) {
+				t.Errorf("timestamp %v, want %v", got.Timestamp, timestamp)
+			}
+			checks := []struct {
+				field     string
+				got, want interface{}
+			}{
+				{"ip address", got.IPAddress, f.want.IPAddress},
+				{"client ident", got.ClientIdent, f.want.ClientIdent},
+				{"client auth", got.ClientAuth, f.want.ClientAuth},
+				{"method", got.RequestMethod, f.want.RequestMethod},
+				{"uri", got.RequestURI, f.want.RequestURI},
+				{"path", got.RequestPath, f.want.RequestPath},
+				{"params", got.RequestParams, f.want.RequestParams},
+				{"protocol", got.RequestProtocol, f.want.RequestProtocol},
+				{"status", got.Status, f.want.Status},
+				{"size", got.Size, f.want.Size},
+				{"referrer", got.Referrer, f.want.Referrer},
+				{"user agent", got.ClientVersion, f.want.ClientVersion},
+				{"format", got.Format(), f.format},
+			}
+			for _, c := range checks {
+				if c.got != c.want {
+					t.Errorf("%v %q, want %q", c.field, c.got, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseLogLineIdentifiesDuplicates(t *testing.T) {
+	line := fixtures[1].line
+	a, err := ParseLogLine(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ParseLogLine(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(a.GetUUID()) != string(b.GetUUID()) {
+		t.Error("the same line was given different ids")
+	}
+	c, err := ParseLogLine(fixtures[0].line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(a.GetUUID()) == string(c.GetUUID()) {
+		t.Error("different lines were given the same id")
+	}
+}
+
+// baselineID returns the id earlier versions stored for a line: the base64url encoding of what was meant
+// to be its hash, cut to the 16 bytes of the id column
+func baselineID(line string) string {
+	encoded := base64.URLEncoding.EncodeToString(sha1.New().Sum([]byte(line)))
+	return encoded[:16]
+}
+
+func TestParseLogLineKeepsLegacyID(t *testing.T) {
+	line := fixtures[0].line
+	entry, err := ParseLogLine(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(entry.GetLegacyUUID()), baselineID(line); got != want {
+		t.Errorf("legacy id %q, want %q", got, want)
+	}
+	short := "short"
+	got := base64.URLEncoding.EncodeToString([]byte(legacyPrefix(short)))
+	if want := baselineID(short); got != want {
+		t.Errorf("legacy id of a short line %q, want %q", got, want)
+	}
+}
+
+func BenchmarkParseLogLine(b *testing.B) {
+	for _, f := range fixtures {
+		b.Run(f.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, err := ParseLogLine(f.line)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package httplog
+
+import (
+	"strings"
+	"sync"
+)
+
+// tokenizer splits a log line into words, reusing its buffers between lines.
+// Words are substrings of the line unless they contain escape sequences, so most lines need no per-word allocation.
+type tokenizer struct {
+	words   []string
+	columns []int
+}
+
+var tokenizerPool = sync.Pool{
+	New: func() interface{} {
+		return &tokenizer{words: make([]string, 0, 16), columns: make([]int, 0, 16)}
+	},
+}
+
+// split breaks a line into space separated words.
+// A word starting with a quote runs until a closing quote followed by a space or the end of the line,
+// and may contain Apache escape sequences; a word starting with a bracket runs until the closing bracket.
+// The returned slice is only valid until the tokenizer is used again.
+func (t *tokenizer) split(line string) ([]string, error) {
+	t.words = t.words[:0]
+	t.columns = t.columns[:0]
+	i := 0
+	for i < len(line) {
+		if line[i] == ' ' {
+			i++
+			continue
+		}
+		switch line[i] {
+		case '"':
+			end, escaped := findClosingQuote(line, i+1)
+			if end < 0 {
+				return nil, &ParseError{Column: i + 1, Reason: "unterminated quote"}
+			}
+			word := line[i+1 : end]
+			if escaped {
+				word = unescape(word)
+			}
+			t.add(word, i+1)
+			i = end + 1
+		case '[':
+			end := strings.IndexByte(line[i+1:], ']')
+			if end < 0 {
+				return nil, &ParseError{Column: i + 1, Reason: "unterminated bracket"}
+			}
+			t.add(line[i+1:i+1+end], i+1)
+			i = i + end + 2
+		default:
+			end := strings.IndexByte(line[i:], ' ')
+			if end < 0 {
+				end = len(line) - i
+			}
+			t.add(strings.TrimSpace(line[i:i+end]), i)
+			i += end
+		}
+	}
+	return t.words, nil
+}
+
+// column reports the 1-based column at which the nth word starts
+func (t *tokenizer) column(n int) int {
+	return t.columns[n] + 1
+}
+
+func (t *tokenizer) add(word string, offset int) {
+	t.words = append(t.words, word)
+	t.columns = append(t.columns, offset)
+}
+
+// findClosingQuote returns the offset of the quote ending a quoted word that starts at offset start,
+// or -1 if there is none. Quotes that are escaped, or that are not followed by a space or the end of the line,
+// are treated as part of the word. It also reports whether the word contains escape sequences.
+func findClosingQuote(line string, start int) (int, bool) {
+	escaped := false
+	for i := start; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			escaped = true
+			i++
+		case '"':
+			if i+1 == len(line) || line[i+1] == ' ' {
+				return i, escaped
+			}
+		}
+	}
+	return -1, escaped
+}
+
+// unescape decodes the escape sequences Apache uses in quoted log fields:
+// \" and \\, the C control character escapes and \xhh hex escapes.
+// Unrecognized escapes are left as they are.
+func unescape(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 == len(s) {
+			b.WriteByte(c)
+			continue
+		}
+		switch s[i+1] {
+		case '"', '\\':
+			b.WriteByte(s[i+1])
+		case 'b':
+			b.WriteByte('\b')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case 'x':
+			if i+3 < len(s) && isHex(s[i+2]) && isHex(s[i+3]) {
+				b.WriteByte(unhex(s[i+2])<<4 | unhex(s[i+3]))
+				i += 3
+				continue
+			}
+			b.WriteString(s[i : i+2])
+		default:
+			b.WriteString(s[i : i+2])
+		}
+		i++
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
@@ -0,0 +1,142 @@
+package httplog
+
+import (
+	"errors"
+	"testing"
+)
+
+const linePrefix = `10.0.0.1 - - [10/Oct/2000:13:55:36 -0700] `
+
+func TestParseLogLineEscapes(t *testing.T) {
+	tests := []struct {
+		name      string
+		rest      string
+		uri       string
+		referrer  string
+		userAgent string
+	}{
+		{
+			name:      "quote in the request",
+			rest:      `"GET /say\"hello\" HTTP/1.1" 200 5 "-" "curl/8.0"`,
+			uri:       `/say"hello"`,
+			referrer:  "-",
+			userAgent: "curl/8.0",
+		},
+		{
+			name:      "quote in the referrer",
+			rest:      `"GET / HTTP/1.1" 200 5 "http://example.com/?q=\"go\"" "curl/8.0"`,
+			uri:       "/",
+			referrer:  `http://example.com/?q="go"`,
+			userAgent: "curl/8.0",
+		},
+		{
+			name:      "quote in the user agent",
+			rest:      `"GET / HTTP/1.1" 200 5 "-" "Bot \"x\" 1.0"`,
+			uri:       "/",
+			referrer:  "-",
+			userAgent: `Bot "x" 1.0`,
+		},
+		{
+			name:      "quote followed by a space inside a field",
+			rest:      `"GET / HTTP/1.1" 200 5 "-" "a \" b"`,
+			uri:       "/",
+			referrer:  "-",
+			userAgent: `a " b`,
+		},
+		{
+			name:      "backslash",
+			rest:      `"GET /a\\b HTTP/1.1" 200 5 "-" "ends with \\"`,
+			uri:       `/a\b`,
+			referrer:  "-",
+			userAgent: `ends with \`,
+		},
+		{
+			name:      "control and hex escapes",
+			rest:      `"GET /\x41\tb HTTP/1.1" 200 5 "-" "\x7a\n"`,
+			uri:       "/A\tb",
+			referrer:  "-",
+			userAgent: "z\n",
+		},
+		{
+			name:      "unknown escape left alone",
+			rest:      `"GET /\q HTTP/1.1" 200 5 "-" "\xZZ"`,
+			uri:       `/\q`,
+			referrer:  "-",
+			userAgent: `\xZZ`,
+		},
+		{
+			name:      "unescaped quote inside a field",
+			rest:      `"GET /a"b HTTP/1.1" 200 5 "-" "curl/8.0"`,
+			uri:       `/a"b`,
+			referrer:  "-",
+			userAgent: "curl/8.0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, mode := range []ParseMode{Strict, Lenient} {
+				entry, err := ParseLogLineMode(linePrefix+tt.rest, mode)
+				if err != nil {
+					t.Fatalf("%v: %v", mode, err)
+				}
+				if entry.RequestURI != tt.uri {
+					t.Errorf("%v: uri %q, want %q", mode, entry.RequestURI, tt.uri)
+				}
+				if entry.Referrer != tt.referrer {
+					t.Errorf("%v: referrer %q, want %q", mode, entry.Referrer, tt.referrer)
+				}
+				if entry.ClientVersion != tt.userAgent {
+					t.Errorf("%v: user agent %q, want %q", mode, entry.ClientVersion, tt.userAgent)
+				}
+			}
+		})
+	}
+}
+
+func TestParseLogLineUnterminated(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		reason string
+		column int
+	}{
+		{
+			name:   "request",
+			line:   linePrefix + `"GET / HTTP/1.1 200 5`,
+			reason: "unterminated quote",
+			column: len(linePrefix) + 1,
+		},
+		{
+			name:   "escaped closing quote",
+			line:   linePrefix + `"GET / HTTP/1.1" 200 5 "-" "curl/8.0\"`,
+			reason: "unterminated quote",
+			column: len(linePrefix) + len(`"GET / HTTP/1.1" 200 5 "-" `) + 1,
+		},
+		{
+			name:   "referrer",
+			line:   linePrefix + `"GET / HTTP/1.1" 200 5 "http://example.com "curl/8.0"x`,
+			reason: "unterminated quote",
+			column: len(linePrefix) + len(`"GET / HTTP/1.1" 200 5 `) + 1,
+		},
+		{
+			name:   "timestamp",
+			line:   `10.0.0.1 - - [10/Oct/2000:13:55:36 -0700 "GET / HTTP/1.1" 200 5`,
+			reason: "unterminated bracket",
+			column: len(`10.0.0.1 - - `) + 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, mode := range []ParseMode{Strict, Lenient} {
+				_, err := ParseLogLineMode(tt.line, mode)
+				var pe *ParseError
+				if !errors.As(err, &pe) {
+					t.Fatalf("%v: got %v, want a *ParseError", mode, err)
+				}
+				if pe.Reason != tt.reason || pe.Column != tt.column {
+					t.Errorf("%v: %q at column %v, want %q at column %v", mode, pe.Reason, pe.Column, tt.reason, tt.column)
+				}
+			}
+		})
+	}
+}
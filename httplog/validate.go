@@ -0,0 +1,124 @@
+package httplog
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// ParseMode controls how fields that fail validation are handled
+type ParseMode int
+
+const (
+	// Lenient accepts lines with invalid fields, marking them as parse errors
+	Lenient ParseMode = iota
+	// Strict rejects lines with any invalid field
+	Strict
+)
+
+func (m ParseMode) String() string {
+	switch m {
+	case Strict:
+		return "strict"
+	default:
+		return "lenient"
+	}
+}
+
+// LookupParseMode returns the parse mode with the given name
+func LookupParseMode(name string) (ParseMode, error) {
+	switch strings.ToLower(name) {
+	case "strict":
+		return Strict, nil
+	case "lenient", "":
+		return Lenient, nil
+	}
+	return Lenient, fmt.Errorf("unknown parse mode: %v", name)
+}
+
+// Names of the fields reported by validation
+const (
+	fieldIPAddress   = "ipaddress"
+	fieldClientIdent = "clientident"
+	fieldClientAuth  = "clientauth"
+	fieldTimestamp   = "timestamp"
+	fieldRequest     = "request"
+	fieldMethod      = "method"
+	fieldProtocol    = "protocol"
+	fieldStatus      = "status"
+	fieldSize        = "size"
+)
+
+// fieldNames lists the fields of the common log format in order
+var fieldNames = []string{fieldIPAddress, fieldClientIdent, fieldClientAuth, fieldTimestamp, fieldRequest, fieldStatus, fieldSize}
+
+// requiredFields is the number of fields in the common log format; the combined format adds the referrer and user agent
+var requiredFields = len(fieldNames)
+
+func validIPAddress(s string) bool {
+	_, err := netip.ParseAddr(s)
+	return err == nil
+}
+
+// validMethod reports whether a request method is an HTTP token
+func validMethod(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isTokenChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isTokenChar(c byte) bool {
+	if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+// validProtocol reports whether a request protocol looks like HTTP/<major> or HTTP/<major>.<minor>
+func validProtocol(s string) bool {
+	if !strings.HasPrefix(s, "HTTP/") {
+		return false
+	}
+	major, minor, dotted := strings.Cut(s[len("HTTP/"):], ".")
+	return isDigits(major) && (!dotted || isDigits(minor))
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseStatus parses an HTTP status code, reporting whether it is in the valid range
+func parseStatus(s string) (int64, bool) {
+	status, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return status, status >= 100 && status <= 599
+}
+
+// parseSize parses a response size, where "-" means no bytes were sent
+func parseSize(s string) (int64, bool) {
+	if s == "-" {
+		return 0, true
+	}
+	size, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || size < 0 {
+		return 0, false
+	}
+	return size, true
+}
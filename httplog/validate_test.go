@@ -0,0 +1,136 @@
+package httplog
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseModes(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		// field is the field reported in strict mode and recorded in lenient mode
+		field  string
+		reason string
+		// column is where the problem is reported, found as the offset of this text in the line
+		at string
+	}{
+		{
+			name:   "ip address",
+			line:   `not-an-ip - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.1" 200 5`,
+			field:  fieldIPAddress,
+			reason: "invalid ip address",
+			at:     "not-an-ip",
+		},
+		{
+			name:   "timestamp",
+			line:   `10.0.0.1 - - [10/Foo/2000:13:55:36 -0700] "GET / HTTP/1.1" 200 5`,
+			field:  fieldTimestamp,
+			reason: "invalid timestamp",
+			at:     "10/Foo",
+		},
+		{
+			name:   "method",
+			line:   `10.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "G(T / HTTP/1.1" 200 5`,
+			field:  fieldMethod,
+			reason: "invalid request method",
+			at:     "G(T",
+		},
+		{
+			name:   "protocol",
+			line:   `10.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTPT/1.1" 200 5`,
+			field:  fieldProtocol,
+			reason: "invalid request protocol",
+			at:     "GET",
+		},
+		{
+			name:   "request without a protocol",
+			line:   `10.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /" 200 5`,
+			field:  fieldRequest,
+			reason: "request protocol not specified",
+			at:     `" 200`,
+		},
+		{
+			name:   "empty request",
+			line:   `10.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "" 200 5`,
+			field:  fieldRequest,
+			reason: "request method not specified",
+			at:     `" 200`,
+		},
+		{
+			name:   "status out of range",
+			line:   `10.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.1" 999 5`,
+			field:  fieldStatus,
+			reason: "invalid status",
+			at:     "999",
+		},
+		{
+			name:   "negative size",
+			line:   `10.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.1" 200 -5`,
+			field:  fieldSize,
+			reason: "invalid size",
+			at:     "-5",
+		},
+		{
+			name:   "missing size",
+			line:   `10.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.1" 200`,
+			field:  fieldSize,
+			reason: "missing size",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			column := len(tt.line) + 1
+			if tt.at != "" {
+				column = strings.Index(tt.line, tt.at) + 1
+			}
+
+			_, err := ParseLogLineMode(tt.line, Strict)
+			var pe *ParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("strict: got %v, want a *ParseError", err)
+			}
+			if pe.Field != tt.field || pe.Reason != tt.reason || pe.Column != column {
+				t.Errorf("strict: %v %q at column %v, want %v %q at column %v", pe.Field, pe.Reason, pe.Column, tt.field, tt.reason, column)
+			}
+
+			entry, err := ParseLogLineMode(tt.line, Lenient)
+			if err != nil {
+				t.Fatalf("lenient: %v", err)
+			}
+			if !entry.IsParseError() {
+				t.Error("lenient: not marked as a parse error")
+			}
+			if want := []string{tt.field}; !reflect.DeepEqual(entry.GetBadFields(), want) {
+				t.Errorf("lenient: bad fields %v, want %v", entry.GetBadFields(), want)
+			}
+		})
+	}
+}
+
+func TestLenientKeepsValidFields(t *testing.T) {
+	entry, err := ParseLogLineMode(`10.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /a HTTP/1.1" abc 5 "-" "curl/8.0"`, Lenient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.RequestURI != "/a" || entry.Size != 5 || entry.ClientVersion != "curl/8.0" {
+		t.Errorf("valid fields lost: uri %q, size %v, user agent %q", entry.RequestURI, entry.Size, entry.ClientVersion)
+	}
+	if !reflect.DeepEqual(entry.GetBadFields(), []string{fieldStatus}) {
+		t.Errorf("bad fields %v, want [status]", entry.GetBadFields())
+	}
+}
+
+func TestLookupParseMode(t *testing.T) {
+	for name, want := range map[string]ParseMode{"strict": Strict, "STRICT": Strict, "lenient": Lenient, "": Lenient} {
+		got, err := LookupParseMode(name)
+		if err != nil || got != want {
+			t.Errorf("LookupParseMode(%q) = %v, %v; want %v", name, got, err, want)
+		}
+	}
+	if _, err := LookupParseMode("loose"); err == nil {
+		t.Error("LookupParseMode accepted an unknown mode")
+	}
+}
@@ -1,217 +1,301 @@
 package main
 
 import (
-	"bufio"
-	"compress/gzip"
 	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
 	"strings"
-	"sync"
-	"sync/atomic"
-	"time"
+	"text/tabwriter"
 
-	"github.com/infodancer/implog/httplog"
 	"github.com/infodancer/implog/logstore/mysql"
+	"github.com/infodancer/implog/privacy"
+	"github.com/infodancer/implog/resolver"
 
 	"github.com/infodancer/implog/logstore"
 )
 
-var errorCount uint64
-var totalCount uint64
+// Exit codes shared by every command, so that cron jobs and monitoring can tell the outcomes apart
+const (
+	exitOK = 0
+	// exitFailure means the command could not run or stopped before finishing
+	exitFailure = 1
+	// exitUsage means the command line was invalid
+	exitUsage = 2
+	// exitPartial means the command finished, but some of its work failed or, for verify, problems were found
+	exitPartial = 3
+	// exitNothing means the command ran successfully but found nothing to do
+	exitNothing = 4
+)
 
-func main() {
-	var err error
-	logtype := flag.String("logtype", "HTTP", "The log file type (valid: http, smtp; defaults to http)")
-	dir := flag.String("logdir", "", "The directory containing log files to import, which will be recursively scanned")
-	file := flag.String("logfile", "", "The log file to import")
-	dbdriver := flag.String("dbdriver", "mysql", "The type of database to use as a log store (defaults to mysql)")
-	dbconnection := flag.String("dbconnection", "", "The name or ip address of the database host")
-	numCPU := flag.Int("cpu", 4, "The number of cpus to use simultaneously")
-	droptables := flag.Bool("droptables", false, "Drop and recreate the table structure")
-	logname := flag.String("name", "", "The name of the log being read (usually, the hostname of the virtual host)")
-	flag.Parse()
+// command is a subcommand of implog, run with the arguments following its name and returning an exit code
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
 
-	var store logstore.LogStore
-	if *dbdriver == "mysql" {
-		store, err = mysql.New(*dbdriver, *dbconnection)
-		if err != nil {
-			log.Println(err)
-			return
-		}
-		err = store.Open()
-		if err != nil {
-			log.Println(err)
-			return
+// commands lists the subcommands in the order they are shown in the usage message.
+// It is filled in by init, since the commands' help looks up their summaries here.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"import", "Import log files, given on the command line or listed by a configuration file", importCommand},
+		{"schema", "Print the SQL schema, or migrate a database to it", schemaCommand},
+		{"report", "Print the top URLs, referrers and clients, status codes, bandwidth and error hotspots of a log", printReport},
+		{"query", "Print one table of statistics: hits by period, a top-N table, status codes or the list of logs", query},
+		{"export", "Write stored entries as combined log lines, CSV or JSON lines", export},
+		{"verify", "Check the consistency of the store", verify},
+		{"purge", "Delete entries older than the retention age of their log, then anything left unused by them", purge},
+		{"serve", "Run a dashboard and JSON API over the store until interrupted", serveDashboard},
+		{"html", "Write a static HTML site of monthly reports for a log", generateHTML},
+		{"sessions", "Group newly imported entries into sessions", buildSessions},
+		{"rollup", "Rebuild the hourly and daily rollups from the stored entries", rollup},
+		{"resolve", "Look up the names of addresses stored without one", resolveNames},
+		{"geoip", "Record the location and network of stored addresses", locateAddresses},
+		{"useragents", "Parse stored user agents with a uap-core regex database", parseUserAgents},
+		{"referrers", "Split and classify stored referrers", classifyReferrers},
+		{"reprocess-rejects", "Parse the lines in a reject directory again and import those that now parse", reprocessRejects},
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(exitUsage)
+	}
+	name := os.Args[1]
+	// Before there were commands, implog only imported, so a leading flag still means import
+	if strings.HasPrefix(name, "-") && !isHelp(name) {
+		os.Exit(importCommand(os.Args[1:]))
+	}
+	if name == "help" || isHelp(name) {
+		if len(os.Args) > 2 {
+			if c := findCommand(os.Args[2]); c != nil {
+				os.Exit(c.run([]string{"-h"}))
+			}
 		}
-		err = store.Ping(context.Background())
-		if err != nil {
-			log.Println(err)
-			return
+		printUsage()
+		os.Exit(exitOK)
+	}
+	c := findCommand(name)
+	if c == nil {
+		fmt.Fprintf(os.Stderr, "implog: unknown command %q\n\n", name)
+		printUsage()
+		os.Exit(exitUsage)
+	}
+	os.Exit(c.run(os.Args[2:]))
+}
+
+// isHelp reports whether an argument asks for help
+func isHelp(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+// findCommand returns the command with a name, or nil if there is none
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
 		}
-	} else {
-		fmt.Printf("Unrecognized logstore type!")
 	}
+	return nil
+}
+
+// printUsage lists the commands and exit codes
+func printUsage() {
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "usage: implog <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %v\t%v\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run implog help <command>, or implog <command> -h, for the flags of a command.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "exit codes:")
+	fmt.Fprintf(w, "  %v\t%v\n", exitOK, "success")
+	fmt.Fprintf(w, "  %v\t%v\n", exitFailure, "failure: the command could not run or stopped before finishing")
+	fmt.Fprintf(w, "  %v\t%v\n", exitUsage, "invalid command line")
+	fmt.Fprintf(w, "  %v\t%v\n", exitPartial, "partial failure: the command finished but some of its work failed, or verify found problems")
+	fmt.Fprintf(w, "  %v\t%v\n", exitNothing, "nothing to do: nothing was imported, deleted or updated")
+	w.Flush()
+}
 
-	if *droptables {
-		fmt.Printf("Removing existing tables...\n")
-		err = store.Clear(context.Background())
-		if err != nil {
-			log.Println(err)
-			return
+// newFlagSet creates the flag set of a command, whose help shows the command's usage line and summary.
+// Invalid flags exit with exitUsage.
+func newFlagSet(name string, synopsis string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		out := flags.Output()
+		fmt.Fprintf(out, "usage: implog %v %v\n", name, synopsis)
+		if c := findCommand(strings.Fields(name)[0]); c != nil {
+			fmt.Fprintf(out, "\n%v\n", c.summary)
 		}
+		fmt.Fprintf(out, "\nflags:\n")
+		flags.PrintDefaults()
 	}
-	err = store.Init(context.Background())
+	return flags
+}
+
+// exitStatus logs the error of a command that worked through count items, and returns its exit code
+func exitStatus(count int, err error) int {
 	if err != nil {
 		log.Println(err)
-		return
-	}
-	defer store.Close()
-
-	files := make([]string, 0)
-	if len(*file) > 0 {
-		files = append(files, *file)
-	} else if len(*dir) > 0 {
-		filecheck := func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				log.Println(err)
-				return nil
-			}
-			if strings.Contains(path, "access_log") {
-				// log.Println(path, info.Size())
-				files = append(files, path)
-			}
-			return nil
-		}
-
-		err := filepath.Walk(*dir, filecheck)
-		if err != nil {
-			log.Println(err)
-			return
+		if count > 0 {
+			return exitPartial
 		}
+		return exitFailure
 	}
-	var wg sync.WaitGroup
-	cpu := 0
-	for _, lf := range files {
-		if cpu >= *numCPU {
-			wg.Wait()
-			cpu = 0
-		}
-		cpu++
-		wg.Add(1)
-		go importLog(&wg, lf, *logname, *logtype, store)
+	if count == 0 {
+		return exitNothing
 	}
-	wg.Wait()
-	log.Printf("Total inserted %v; total errors %v\n", totalCount, errorCount)
+	return exitOK
+}
+
+// storeOptions are the flags that choose the log store
+type storeOptions struct {
+	dbdriver     *string
+	dbconnection *string
 }
 
-// importLog imports a line oriented log file, transparently handling gzip compression
-func importLog(wg *sync.WaitGroup, file string, logname string, logtype string, store logstore.LogStore) error {
-	defer wg.Done()
-	var fileInsertCount uint64
-	var fileErrorCount uint64
-	start := time.Now()
+// storeFlags adds the flags that choose the log store to a flag set
+func storeFlags(flags *flag.FlagSet) *storeOptions {
+	result := storeOptions{}
+	result.dbdriver = flags.String("dbdriver", "mysql", "The type of database to use as a log store (defaults to mysql)")
+	result.dbconnection = flags.String("dbconnection", "", "The name or ip address of the database host")
+	return &result
+}
 
-	// Get the last modified time of the logfile
-	info, err := os.Stat(file)
+// open connects to the log store chosen by the flags, which still has to be initialized
+func (o *storeOptions) open() (logstore.LogStore, error) {
+	return openStore(*o.dbdriver, *o.dbconnection)
+}
+
+// openInit connects to the log store chosen by the flags and initializes it
+func (o *storeOptions) openInit(ctx context.Context) (logstore.LogStore, error) {
+	store, err := o.open()
 	if err != nil {
-		log.Printf("could not stat %v\n", file)
-		return err
+		return nil, err
 	}
-
-	// Compare it with the store modification time, if any
-	_, modified, err := store.LookupLogFile(file, info.ModTime())
+	err = store.Init(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return store, nil
+}
 
-	// Check the date comparison and return if nothing new
-	if modified.After(info.ModTime()) || modified.Equal(info.ModTime()) {
-		return nil
-	}
+// passwordEnv is the environment variable from which the store password is taken, if it is set,
+// so that it need not appear in -dbconnection
+const passwordEnv = "IMPLOG_DB_PASSWORD"
 
-	f, err := os.Open(file)
-	if err != nil {
-		log.Printf("could not read %v\n", file)
-		return err
+// openStore connects to the log store of the given type, using the password in passwordEnv if it is set
+func openStore(dbdriver string, dbconnection string) (logstore.LogStore, error) {
+	if password, ok := os.LookupEnv(passwordEnv); ok {
+		var err error
+		dbconnection, err = withPassword(dbdriver, dbconnection, password)
+		if err != nil {
+			return nil, err
+		}
 	}
-	defer f.Close()
-
-	bReader := bufio.NewReader(f)
-	var scanner *bufio.Scanner
+	return connectStore(dbdriver, dbconnection)
+}
 
-	// If we detect gzip, then make a gzip reader, then wrap it in a scanner
-	// log.Printf("Checking for compression...\n")
-	gzipped, err := isFileContentGzip(bReader)
-	if err != nil {
-		log.Printf("err checking compression: %v\n", err)
-		return err
+// withPassword sets the password in a connection string for the log store of the given type
+func withPassword(dbdriver string, dbconnection string, password string) (string, error) {
+	if dbdriver == "mysql" {
+		return mysql.WithPassword(dbconnection, password)
 	}
-	if gzipped {
-		gzipReader, err := gzip.NewReader(bReader)
+	return "", fmt.Errorf("unrecognized logstore type: %v", dbdriver)
+}
+
+// connectStore connects to the log store of the given type
+func connectStore(dbdriver string, dbconnection string) (logstore.LogStore, error) {
+	var store logstore.LogStore
+	var err error
+	if dbdriver == "mysql" {
+		store, err = mysql.New(dbdriver, dbconnection)
 		if err != nil {
-			log.Printf("err during decompression: %v\n", err)
-			return err
+			return nil, err
 		}
-		scanner = bufio.NewScanner(gzipReader)
 	} else {
-		scanner = bufio.NewScanner(bReader)
-	}
-
-	var lc int64
-	for scanner.Scan() {
-		ctx := context.Background()
-		line := scanner.Text()
-		if strings.EqualFold(logtype, "HTTP") {
-			entrydata, err := httplog.ParseLogLine(line)
-			if err != nil {
-				log.Printf("error parsing line %v in %v: %v\n", lc, file, err)
-				log.Println(line)
-				continue
-			}
-			entrydata.SetLogName(logname)
-			entrydata.SetLogFile(file)
-			entrydata.SetLogFileModified(info.ModTime())
-			err = store.WriteHTTPLogEntry(ctx, entrydata)
-			if err != nil {
-				if !strings.Contains(err.Error(), "Duplicate entry") {
-					log.Printf("error adding to store: %v", err)
-					fileErrorCount++
-				}
-			} else {
-				fileInsertCount++
-			}
-		}
-		lc++
+		return nil, fmt.Errorf("unrecognized logstore type: %v", dbdriver)
 	}
-	err = scanner.Err()
+	err = store.Open()
 	if err != nil {
-		log.Printf("error: %v", err)
+		return nil, err
 	}
+	err = store.Ping(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
 
-	t := time.Now()
-	elapsed := t.Sub(start)
-	atomic.AddUint64(&errorCount, fileErrorCount)
-	atomic.AddUint64(&totalCount, fileInsertCount)
-
-	if fileInsertCount > 0 {
-		log.Printf("Processing: %v\n", file)
-		log.Printf("parsed %v lines in %v taking %v \n", lc, file, elapsed)
-		log.Printf("inserted %v; errors %v\n", fileInsertCount, fileErrorCount)
+// resolverFlags adds the flags that configure reverse DNS lookups to a flag set,
+// returning a function that creates a resolver from them once the flags have been parsed
+func resolverFlags(flags *flag.FlagSet) func() *resolver.Resolver {
+	defaults := resolver.DefaultConfig()
+	server := flags.String("resolver", "", "The host:port of the DNS server used for reverse lookups (defaults to the system resolver)")
+	workers := flags.Int("rdns-workers", defaults.Workers, "The number of reverse lookups to perform at once")
+	timeout := flags.Duration("rdns-timeout", defaults.Timeout, "The time allowed for each reverse lookup")
+	return func() *resolver.Resolver {
+		config := defaults
+		config.Server = *server
+		config.Workers = *workers
+		config.Timeout = *timeout
+		return resolver.New(config)
 	}
+}
+
+// listFlag is a flag that may be given more than once, collecting each value
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
 	return nil
 }
 
-func isFileContentGzip(bReader *bufio.Reader) (bool, error) {
-	testBytes, err := bReader.Peek(2)
-	if err != nil {
-		return false, err
+// privacyFlags adds the flags that configure the privacy policy to a flag set,
+// returning a function that creates the policy from them once the flags have been parsed
+func privacyFlags(flags *flag.FlagSet) func() (*privacy.Policy, error) {
+	options := flags.String("privacy", "", "A comma separated list of privacy options to apply before storing entries: truncate-ip, hash-ip, drop-auth, scrub")
+	keyFile := flags.String("privacy-key-file", "", "The file containing the secret key used by hash-ip and to disguise entry ids and session cookies; required by truncate-ip, hash-ip and scrub")
+	var scrub listFlag
+	flags.Var(&scrub, "privacy-scrub", "A regular expression matching decoded name=value query string pairs to redact; may be given more than once (implies scrub)")
+	return func() (*privacy.Policy, error) {
+		return loadPolicy(strings.Split(*options, ","), *keyFile, scrub)
 	}
-	if testBytes[0] == 31 && testBytes[1] == 139 {
-		return true, nil
+}
+
+// loadPolicy creates a privacy policy, reading its key from a file if one is given
+func loadPolicy(options []string, keyFile string, scrub []string) (*privacy.Policy, error) {
+	var key []byte
+	if len(keyFile) > 0 {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		key, err = privacy.ReadKey(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return privacy.New(options, key, scrub)
+}
+
+// siteDomains lists the domains of a site: its log name, which is normally its host name, and any others given
+func siteDomains(logname string, ownDomains string) []string {
+	domains := []string{logname}
+	for _, d := range strings.Split(ownDomains, ",") {
+		domains = append(domains, strings.TrimSpace(d))
 	}
-	return false, nil
+	return domains
 }
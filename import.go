@@ -0,0 +1,678 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/infodancer/implog/botclass"
+	"github.com/infodancer/implog/geoip"
+	"github.com/infodancer/implog/httplog"
+	"github.com/infodancer/implog/latency"
+	"github.com/infodancer/implog/logstore"
+	"github.com/infodancer/implog/params"
+	"github.com/infodancer/implog/privacy"
+	"github.com/infodancer/implog/referrer"
+	"github.com/infodancer/implog/rejects"
+	"github.com/infodancer/implog/resolver"
+	"github.com/infodancer/implog/useragent"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// importCommand imports log files into the store, either those named by its flags or the sites listed in a configuration file
+func importCommand(args []string) int {
+	flags := newFlagSet("import", "[-config file [-name site]] | [-name log] [-logdir dir | -logfile file] [flags]")
+	logtype := flags.String("logtype", "HTTP", "The log file type (valid: http, smtp; defaults to http)")
+	dir := flags.String("logdir", "", "The directory containing log files to import, which will be recursively scanned")
+	file := flags.String("logfile", "", "The log file to import")
+	configFile := flags.String("config", "", "A YAML file listing the sites to import and the settings they share, instead of the other flags; -name then selects one site")
+	stores := storeFlags(flags)
+	numCPU := flags.Int("cpu", 4, "The number of cpus to use simultaneously")
+	droptables := flags.Bool("droptables", false, "Drop and recreate the table structure")
+	logname := flags.String("name", "", "The name of the log being read (usually, the hostname of the virtual host)")
+	rejectDir := flags.String("rejects", "", "The directory in which to record lines that could not be parsed")
+	parseModeName := flags.String("parse-mode", "lenient", "How to handle fields that fail validation (strict rejects the line, lenient stores it marked as a parse error)")
+	noRDNS := flags.Bool("no-rdns", false, "Do not look up the names of new ip addresses (they can be filled in later with implog resolve)")
+	newResolver := resolverFlags(flags)
+	geoCity := flags.String("geoip-city", "", "The GeoLite2 or DB-IP city (or country) database used to locate new ip addresses")
+	geoASN := flags.String("geoip-asn", "", "The GeoLite2 or DB-IP ASN database used to find the network of new ip addresses")
+	uaRegexes := flags.String("uap-regexes", "", "The uap-core regexes.yaml file used to parse new user agents")
+	ownDomains := flags.String("own-domains", "", "A comma separated list of the site's domains, in addition to the log name, whose referrals are internal")
+	paramsAllow := flags.String("params-allow", "", "A comma separated list of the query string parameters to store, such as utm_*,q (defaults to all)")
+	paramsDeny := flags.String("params-deny", "", "A comma separated list of query string parameters never to store, such as token,session*")
+	newPolicy := privacyFlags(flags)
+	botRate := flags.Int("bot-rate", botclass.DefaultRateLimit, "The number of requests per minute from one address above which its traffic is classified as automated")
+	dryRun := flags.Bool("dry-run", false, "Find, check and parse the files, reporting for each how many entries are new and how many are already stored, without writing anything")
+	summary := flags.String("summary", "", "A file to which to write a JSON summary of the import, with the counts for each file, the reasons lines were rejected and the store latency")
+	metricsListen := flags.String("metrics-listen", "", "An address, such as :9100, on which to serve Prometheus metrics at /metrics while importing")
+	flags.Parse(args)
+	opts := importOptions{dryRun: *dryRun, summary: *summary, metricsListen: *metricsListen}
+
+	if *dryRun && *droptables {
+		log.Println("-droptables cannot be used with -dry-run")
+		return exitUsage
+	}
+	if len(*configFile) > 0 {
+		return importSites(*configFile, *logname, opts)
+	}
+	if len(*file) == 0 && len(*dir) == 0 && !*droptables {
+		log.Println("a log file or directory must be specified with -logfile or -logdir")
+		return exitUsage
+	}
+
+	parseMode, err := httplog.LookupParseMode(*parseModeName)
+	if err != nil {
+		log.Println(err)
+		return exitUsage
+	}
+	policy, err := newPolicy()
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+
+	store, err := stores.open()
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	im := newImporter(store, parseMode)
+	im.policy = policy
+	im.options = opts
+	if *dryRun {
+		return im.tryFiles(*file, *dir, *logname, *logtype, *numCPU)
+	}
+	store.SetPrivacyPolicy(policy.String())
+	var verifier *resolver.Resolver
+	if !*noRDNS {
+		// Anonymized addresses are not worth looking up, though crawlers are still verified by their real address
+		if !policy.ChangesIP() {
+			store.SetResolver(newResolver())
+		}
+		verifier = newResolver()
+	}
+	im.classifier = botclass.New(verifier, *botRate)
+	store.SetReferrerClassifier(referrer.NewClassifier(siteDomains(*logname, *ownDomains)))
+	store.SetParamFilter(params.NewFilter(strings.Split(*paramsAllow, ","), strings.Split(*paramsDeny, ",")))
+	if len(*geoCity) > 0 || len(*geoASN) > 0 {
+		geo, err := geoip.Open(*geoCity, *geoASN)
+		if err != nil {
+			log.Println(err)
+			return exitFailure
+		}
+		defer geo.Close()
+		store.SetGeoIP(geo)
+	}
+	if len(*uaRegexes) > 0 {
+		parser, err := useragent.Load(*uaRegexes)
+		if err != nil {
+			log.Println(err)
+			return exitFailure
+		}
+		store.SetUserAgentParser(parser)
+	}
+
+	if len(*rejectDir) > 0 {
+		im.rejectWriter, err = rejects.NewWriter(*rejectDir, rejects.DefaultMaxSize)
+		if err != nil {
+			log.Println(err)
+			return exitFailure
+		}
+		defer im.rejectWriter.Close()
+	}
+
+	if *droptables {
+		fmt.Printf("Removing existing tables...\n")
+		err = store.Clear(context.Background())
+		if err != nil {
+			log.Println(err)
+			return exitFailure
+		}
+	}
+	err = store.Init(context.Background())
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer store.Close()
+
+	files, err := commandLineFiles(*file, *dir)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	err = im.begin()
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	im.importFiles(files, *logname, *logtype, *numCPU)
+	return im.finish()
+}
+
+// importOptions are the settings of an import that do not concern the logs or the store
+type importOptions struct {
+	dryRun bool
+	// summary is the file to which to write the JSON summary, if any
+	summary string
+	// metricsListen is the address on which to serve metrics while importing, if any
+	metricsListen string
+}
+
+// tryFiles makes a dry run of importing the files given on the command line into a read-only store,
+// printing what would become of each
+func (im *importer) tryFiles(file string, dir string, logname string, logtype string, cpu int) int {
+	im.dryRun = true
+	im.store.SetReadOnly(true)
+	err := im.store.Init(context.Background())
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer im.store.Close()
+	files, err := commandLineFiles(file, dir)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	err = im.begin()
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	im.importFiles(files, logname, logtype, cpu)
+	return im.finish()
+}
+
+// commandLineFiles lists the file given with -logfile, or the access logs below the directory given with -logdir
+func commandLineFiles(file string, dir string) ([]string, error) {
+	if len(file) > 0 {
+		return []string{file}, nil
+	}
+	if len(dir) > 0 {
+		return findLogFiles(dir, func(path string) bool {
+			return strings.Contains(path, "access_log")
+		})
+	}
+	return []string{}, nil
+}
+
+// findLogFiles lists the files below a directory whose paths match
+func findLogFiles(dir string, match func(path string) bool) ([]string, error) {
+	files := make([]string, 0)
+	filecheck := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Println(err)
+			return nil
+		}
+		if !info.IsDir() && match(path) {
+			files = append(files, path)
+		}
+		return nil
+	}
+	err := filepath.Walk(dir, filecheck)
+	return files, err
+}
+
+// importer parses log lines and writes their entries to a store, counting what became of them
+type importer struct {
+	store          logstore.LogStore
+	parseMode      httplog.ParseMode
+	policy         *privacy.Policy
+	classifier     *botclass.Classifier
+	rejectWriter   *rejects.Writer
+	rejectCounts   *rejects.Counter
+	badFieldCounts *rejects.Counter
+	// dryRun checks entries against the store without writing them, remembering every entry seen
+	dryRun      bool
+	seen        map[string]bool
+	seenMutex   *sync.Mutex
+	stats       []*fileStats
+	statsMutex  *sync.Mutex
+	inserted    uint64
+	errors      uint64
+	failedFiles uint64
+	// storeLatency times each write to the store, or each lookup in a dry run
+	storeLatency *latency.Histogram
+	started      time.Time
+	// progress is shown while importing, if standard error is a terminal
+	progress *progress
+	metrics  *importMetrics
+	// stopMetrics stops serving the metrics, if they are served
+	stopMetrics func()
+	options     importOptions
+	// unverified holds the entries written as unverified crawlers, to be reclassified once they are verified
+	unverified      map[unverifiedCrawler]bool
+	unverifiedMutex *sync.Mutex
+}
+
+// newImporter creates an importer writing to a store, with no privacy policy, rejects directory or resolver
+func newImporter(store logstore.LogStore, parseMode httplog.ParseMode) *importer {
+	result := importer{}
+	result.store = store
+	result.parseMode = parseMode
+	result.policy = &privacy.Policy{}
+	result.classifier = botclass.New(nil, botclass.DefaultRateLimit)
+	result.unverified = make(map[unverifiedCrawler]bool)
+	result.unverifiedMutex = &sync.Mutex{}
+	result.rejectCounts = rejects.NewCounter()
+	result.badFieldCounts = rejects.NewCounter()
+	result.seen = make(map[string]bool)
+	result.seenMutex = &sync.Mutex{}
+	result.statsMutex = &sync.Mutex{}
+	result.storeLatency = latency.New()
+	result.started = time.Now()
+	result.metrics = newImportMetrics(prometheus.NewRegistry())
+	return &result
+}
+
+// importFiles imports log files into the store, up to cpu of them at once
+func (im *importer) importFiles(files []string, logname string, logtype string, cpu int) {
+	im.progress.expect(files)
+	var wg sync.WaitGroup
+	running := 0
+	for _, lf := range files {
+		if running >= cpu {
+			wg.Wait()
+			running = 0
+		}
+		running++
+		wg.Add(1)
+		go func(file string) {
+			defer wg.Done()
+			err := im.importLog(file, logname, logtype)
+			if err != nil {
+				atomic.AddUint64(&im.failedFiles, 1)
+			}
+		}(lf)
+	}
+	wg.Wait()
+}
+
+// fileStats counts what became of the lines of one log file
+type fileStats struct {
+	// read and lines are updated atomically, as the progress line reads them while the file is imported
+	read    int64
+	lines   int64
+	done    int32
+	file    string
+	logname string
+	size    int64
+	// skipped is set for a file unchanged since it was last imported
+	skipped    bool
+	compressed bool
+	// formats counts the lines parsed in each format
+	formats    map[string]int64
+	parsed     int64
+	rejected   int64
+	inserted   int64
+	duplicates int64
+	errors     int64
+	elapsed    time.Duration
+	// failure is the error that stopped the file being imported, if any
+	failure string
+}
+
+// isDone reports whether the file has been imported, skipped or given up on
+func (st *fileStats) isDone() bool {
+	return atomic.LoadInt32(&st.done) != 0
+}
+
+// importLog imports a line oriented log file, transparently handling gzip compression
+func (im *importer) importLog(file string, logname string, logtype string) (err error) {
+	stats := &fileStats{file: file, logname: logname, formats: make(map[string]int64)}
+	counters := im.metrics.forLog(logname)
+	im.statsMutex.Lock()
+	im.stats = append(im.stats, stats)
+	im.statsMutex.Unlock()
+	start := time.Now()
+	defer func() {
+		stats.elapsed = time.Since(start)
+		if err != nil {
+			stats.failure = err.Error()
+		}
+		atomic.StoreInt32(&stats.done, 1)
+	}()
+
+	// Get the last modified time of the logfile
+	info, err := os.Stat(file)
+	if err != nil {
+		log.Printf("could not stat %v\n", file)
+		return err
+	}
+	stats.size = info.Size()
+
+	// Compare it with the store modification time, if any
+	_, modified, err := im.store.LookupLogFile(file, info.ModTime())
+	if err != nil {
+		return err
+	}
+
+	// Check the date comparison and return if nothing new
+	if modified.After(info.ModTime()) || modified.Equal(info.ModTime()) {
+		stats.skipped = true
+		return nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		log.Printf("could not read %v\n", file)
+		return err
+	}
+	defer f.Close()
+
+	bReader := bufio.NewReader(&countingReader{r: f, stats: stats})
+	var scanner *bufio.Scanner
+
+	// If we detect gzip, then make a gzip reader, then wrap it in a scanner
+	// log.Printf("Checking for compression...\n")
+	gzipped, err := isFileContentGzip(bReader)
+	if err != nil {
+		log.Printf("err checking compression: %v\n", err)
+		return err
+	}
+	if gzipped {
+		stats.compressed = true
+		gzipReader, err := gzip.NewReader(bReader)
+		if err != nil {
+			log.Printf("err during decompression: %v\n", err)
+			return err
+		}
+		scanner = bufio.NewScanner(gzipReader)
+	} else {
+		scanner = bufio.NewScanner(bReader)
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineno := atomic.AddInt64(&stats.lines, 1)
+		counters.lines.Inc()
+		if strings.EqualFold(logtype, "HTTP") {
+			entrydata, err := httplog.ParseLogLineMode(line, im.parseMode)
+			if err != nil {
+				log.Printf("error parsing line %v in %v: %v\n", lineno, file, err)
+				im.reject(rejects.Record{LogName: logname, LogType: logtype, File: file, Modified: info.ModTime(), Line: lineno, Text: line}, err)
+				stats.rejected++
+				counters.rejected.Inc()
+				continue
+			}
+			stats.parsed++
+			counters.parsed.Inc()
+			stats.formats[entrydata.Format()]++
+			inserted, err := im.write(entrydata, logname, file, info.ModTime())
+			if err != nil {
+				stats.errors++
+				counters.errors.Inc()
+			} else if inserted {
+				stats.inserted++
+				counters.inserted.Inc()
+			} else {
+				stats.duplicates++
+				counters.duplicates.Inc()
+			}
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		log.Printf("error: %v", err)
+	}
+
+	if stats.inserted > 0 && !im.dryRun {
+		log.Printf("Processing: %v\n", file)
+		log.Printf("parsed %v lines in %v taking %v \n", stats.lines, file, time.Since(start))
+		log.Printf("inserted %v; errors %v\n", stats.inserted, stats.errors)
+	}
+	return err
+}
+
+// write classifies a parsed entry, applies the privacy policy and stores it, reporting whether it was new.
+// Entries already in the store are not errors; other failures are logged and counted.
+// In a dry run the entry is only looked up, and reported as new if it would have been stored.
+func (im *importer) write(entrydata *httplog.EntryData, logname string, file string, modified time.Time) (bool, error) {
+	im.countBadFields(entrydata)
+	entrydata.SetLogName(logname)
+	entrydata.SetLogFile(file)
+	entrydata.SetLogFileModified(modified)
+	if im.dryRun {
+		// The policy changes the entry's id, so it is applied before looking the entry up
+		im.policy.Apply(entrydata)
+		return im.check(entrydata)
+	}
+	im.classify(entrydata)
+	ip := entrydata.IPAddress
+	im.policy.Apply(entrydata)
+	start := time.Now()
+	err := im.store.WriteHTTPLogEntry(context.Background(), entrydata)
+	im.observeWrite(time.Since(start))
+	if err != nil {
+		if isDuplicate(err) {
+			return false, nil
+		}
+		log.Printf("error adding to store: %v", err)
+		atomic.AddUint64(&im.errors, 1)
+		return false, err
+	}
+	atomic.AddUint64(&im.inserted, 1)
+	if entrydata.GetTrafficClass() == botclass.Crawler {
+		im.unverifiedMutex.Lock()
+		im.unverified[unverifiedCrawler{logname: logname, address: entrydata.IPAddress, ip: ip}] = true
+		im.unverifiedMutex.Unlock()
+	}
+	return true, nil
+}
+
+// unverifiedCrawler identifies the entries of a log written as an unverified crawler from an address,
+// which is stored as the privacy policy left it, and which was verified by its real address
+type unverifiedCrawler struct {
+	logname string
+	address string
+	ip      string
+}
+
+// reclassifyCrawlers waits for the crawlers queued for verification, then reclassifies the entries
+// written as unverified crawlers before their addresses were looked up
+func (im *importer) reclassifyCrawlers() {
+	im.classifier.Close()
+	var count int64
+	for u := range im.unverified {
+		class, ok := im.classifier.Verified(u.ip)
+		if !ok {
+			continue
+		}
+		n, err := im.store.Reclassify(context.Background(), u.logname, u.address, botclass.Crawler, class)
+		if err != nil {
+			log.Printf("error reclassifying %v: %v\n", u.address, err)
+			continue
+		}
+		count += n
+	}
+	if count > 0 {
+		log.Printf("Reclassified %v crawler entries once their addresses were verified\n", count)
+	}
+}
+
+// check reports whether an entry would be stored: whether it is neither in the store nor seen earlier in the run
+func (im *importer) check(entrydata *httplog.EntryData) (bool, error) {
+	key := entrydata.GetLogName() + "\x00" + string(entrydata.GetUUID())
+	im.seenMutex.Lock()
+	seen := im.seen[key]
+	im.seen[key] = true
+	im.seenMutex.Unlock()
+	if seen {
+		return false, nil
+	}
+	start := time.Now()
+	stored, err := im.store.HasEntry(context.Background(), entrydata)
+	im.observeWrite(time.Since(start))
+	if err != nil {
+		log.Printf("error looking up entry: %v", err)
+		atomic.AddUint64(&im.errors, 1)
+		return false, err
+	}
+	if stored {
+		return false, nil
+	}
+	atomic.AddUint64(&im.inserted, 1)
+	return true, nil
+}
+
+// observeWrite records the time taken to write an entry to the store, or to look it up in a dry run
+func (im *importer) observeWrite(d time.Duration) {
+	im.storeLatency.Add(d)
+	im.metrics.entryWrites.Observe(d.Seconds())
+}
+
+// printDryRun writes what became of each file in a dry run, as a table
+func (im *importer) printDryRun(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "file\tlines\tparsed\trejected\tnew\tduplicates\tformat\t")
+	im.statsMutex.Lock()
+	defer im.statsMutex.Unlock()
+	for _, st := range im.stats {
+		if st.skipped {
+			fmt.Fprintf(tw, "%v\t-\t-\t-\t-\t-\tunchanged\t\n", st.file)
+			continue
+		}
+		formats := make([]string, 0, len(st.formats))
+		for format, count := range st.formats {
+			formats = append(formats, fmt.Sprintf("%v %v", format, count))
+		}
+		sort.Strings(formats)
+		if st.compressed {
+			formats = append([]string{"gzip"}, formats...)
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t\n", st.file, st.lines, st.parsed, st.rejected, st.inserted, st.duplicates, strings.Join(formats, ", "))
+	}
+	return tw.Flush()
+}
+
+// reject records a line that could not be parsed, writing it to the reject files if they are enabled
+func (im *importer) reject(r rejects.Record, err error) {
+	r.Time = time.Now()
+	r.Error = err.Error()
+	r.Reason = err.Error()
+	var pe *httplog.ParseError
+	if errors.As(err, &pe) {
+		r.Reason = pe.Reason
+		r.Column = pe.Column
+	}
+	im.rejectCounts.Add(r.Reason)
+	if im.rejectWriter == nil {
+		return
+	}
+	werr := im.rejectWriter.Write(r)
+	if werr != nil {
+		log.Printf("error writing reject: %v\n", werr)
+	}
+}
+
+// classify records whether an entry came from a person, a crawler, a scanner and so on
+func (im *importer) classify(entry *httplog.EntryData) {
+	entry.SetTrafficClass(im.classifier.Classify(entry.IPAddress, entry.ClientVersion, entry.RequestURI, entry.Status, entry.Timestamp))
+}
+
+// countBadFields counts the invalid fields of an entry that was accepted by lenient parsing
+func (im *importer) countBadFields(entry httplog.Entry) {
+	for _, field := range entry.GetBadFields() {
+		im.badFieldCounts.Add(field)
+	}
+}
+
+// logTotals logs the number of entries inserted and failed, of rejected lines for each reason,
+// and of accepted lines with each invalid field
+func (im *importer) logTotals() {
+	if im.dryRun {
+		log.Printf("Total new %v; total errors %v (dry run, nothing was written)\n", atomic.LoadUint64(&im.inserted), atomic.LoadUint64(&im.errors))
+	} else {
+		log.Printf("Total inserted %v; total errors %v\n", atomic.LoadUint64(&im.inserted), atomic.LoadUint64(&im.errors))
+	}
+	for _, rc := range im.rejectCounts.Counts() {
+		log.Printf("rejected %v: %v\n", rc.Reason, rc.Count)
+	}
+	for _, rc := range im.badFieldCounts.Counts() {
+		log.Printf("accepted with invalid %v: %v\n", rc.Reason, rc.Count)
+	}
+}
+
+// begin starts serving the metrics, if an address was given for them, and showing the progress of the import
+func (im *importer) begin() error {
+	if len(im.options.metricsListen) > 0 {
+		im.store.SetObserver(im.metrics)
+		im.registerUnread()
+		registerStoreMetrics(im.metrics.registry, im.store)
+		stop, err := listenMetrics(im.options.metricsListen, im.metrics.registry)
+		if err != nil {
+			return err
+		}
+		im.stopMetrics = stop
+	}
+	im.progress = startProgress(im)
+	return nil
+}
+
+// finish ends an import once its files have been read: it removes the progress line, prints the table of a dry run,
+// logs the totals and writes the summary, returning the exit code
+func (im *importer) finish() int {
+	if im.stopMetrics != nil {
+		im.stopMetrics()
+	}
+	im.progress.finish()
+	im.reclassifyCrawlers()
+	if im.dryRun {
+		err := im.printDryRun(os.Stdout)
+		if err != nil {
+			log.Println(err)
+			return exitFailure
+		}
+	}
+	im.logTotals()
+	code := im.exitCode()
+	if len(im.options.summary) > 0 {
+		err := im.writeSummary(im.options.summary, code)
+		if err != nil {
+			log.Printf("error writing summary: %v\n", err)
+			return exitFailure
+		}
+	}
+	return code
+}
+
+// exitCode returns the exit code of an import: partial if any entry could not be stored (or looked up, in a dry run)
+// or any file could not be read, and nothing to do if no new entries were found
+func (im *importer) exitCode() int {
+	if atomic.LoadUint64(&im.errors) > 0 || atomic.LoadUint64(&im.failedFiles) > 0 {
+		return exitPartial
+	}
+	if atomic.LoadUint64(&im.inserted) == 0 {
+		return exitNothing
+	}
+	return exitOK
+}
+
+// isDuplicate reports whether a store error was caused by an entry that has already been imported
+func isDuplicate(err error) bool {
+	return errors.Is(err, logstore.ErrDuplicate) || strings.Contains(err.Error(), "Duplicate entry")
+}
+
+func isFileContentGzip(bReader *bufio.Reader) (bool, error) {
+	testBytes, err := bReader.Peek(2)
+	if err != nil {
+		return false, err
+	}
+	if testBytes[0] == 31 && testBytes[1] == 139 {
+		return true, nil
+	}
+	return false, nil
+}
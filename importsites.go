@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/infodancer/implog/botclass"
+	"github.com/infodancer/implog/config"
+	"github.com/infodancer/implog/geoip"
+	"github.com/infodancer/implog/httplog"
+	"github.com/infodancer/implog/logstore"
+	"github.com/infodancer/implog/params"
+	"github.com/infodancer/implog/privacy"
+	"github.com/infodancer/implog/referrer"
+	"github.com/infodancer/implog/rejects"
+	"github.com/infodancer/implog/resolver"
+	"github.com/infodancer/implog/useragent"
+)
+
+// profile is a site from the configuration file with its settings checked and loaded
+type profile struct {
+	site      *config.Site
+	parseMode httplog.ParseMode
+	policy    *privacy.Policy
+}
+
+// importSites imports the logs of every site in a configuration file, or of one of them,
+// applying each site's own settings to its entries, or makes a dry run of doing so
+func importSites(configFile string, only string, opts importOptions) int {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	sites := cfg.Sites
+	if len(only) > 0 {
+		site := cfg.Site(only)
+		if site == nil {
+			log.Printf("%v: no site named %v\n", configFile, only)
+			return exitUsage
+		}
+		sites = []config.Site{*site}
+	}
+
+	// Every site's settings are checked before anything is imported
+	profiles := make([]profile, 0, len(sites))
+	keepsAddresses := false
+	for i := range sites {
+		p := profile{site: &sites[i]}
+		p.parseMode, err = httplog.LookupParseMode(p.site.ParseMode)
+		if err == nil {
+			p.policy, err = loadPolicy(p.site.Privacy.Options, p.site.Privacy.KeyFile, p.site.Privacy.Scrub)
+		}
+		if err != nil {
+			log.Printf("%v: %v\n", p.site.Name, err)
+			return exitFailure
+		}
+		if !p.policy.ChangesIP() {
+			keepsAddresses = true
+		}
+		profiles = append(profiles, p)
+	}
+
+	store, err := openConfigStore(cfg.Store)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	im := newImporter(store, httplog.Lenient)
+	im.options = opts
+	var rdns, verifier *resolver.Resolver
+	if opts.dryRun {
+		// Nothing is written, so the resolver, location and user agent databases and rejects are not needed
+		im.dryRun = true
+		store.SetReadOnly(true)
+	} else {
+		if !cfg.NoRDNS {
+			rc := resolver.DefaultConfig()
+			rc.Server = cfg.Resolver
+			rdns = resolver.New(rc)
+			// The resolver is started by Init, so it is set if any site's addresses are worth looking up
+			if keepsAddresses {
+				store.SetResolver(rdns)
+			}
+			// Crawlers are verified by their real address, whatever the site's policy
+			verifier = resolver.New(rc)
+		}
+		botRate := cfg.BotRate
+		if botRate <= 0 {
+			botRate = botclass.DefaultRateLimit
+		}
+		im.classifier = botclass.New(verifier, botRate)
+		if len(cfg.GeoIP.City) > 0 || len(cfg.GeoIP.ASN) > 0 {
+			geo, err := geoip.Open(cfg.GeoIP.City, cfg.GeoIP.ASN)
+			if err != nil {
+				log.Println(err)
+				return exitFailure
+			}
+			defer geo.Close()
+			store.SetGeoIP(geo)
+		}
+		if len(cfg.UAPRegexes) > 0 {
+			parser, err := useragent.Load(cfg.UAPRegexes)
+			if err != nil {
+				log.Println(err)
+				return exitFailure
+			}
+			store.SetUserAgentParser(parser)
+		}
+		if len(cfg.Rejects) > 0 {
+			im.rejectWriter, err = rejects.NewWriter(cfg.Rejects, rejects.DefaultMaxSize)
+			if err != nil {
+				log.Println(err)
+				return exitFailure
+			}
+			defer im.rejectWriter.Close()
+		}
+	}
+
+	err = store.Init(context.Background())
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer store.Close()
+
+	err = im.begin()
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	for _, p := range profiles {
+		site := p.site
+		files, err := findLogFiles(site.LogDir, func(path string) bool {
+			return site.Matches(filepath.Base(path))
+		})
+		if err != nil {
+			log.Printf("%v: %v\n", site.Name, err)
+			atomic.AddUint64(&im.failedFiles, 1)
+			continue
+		}
+		// Sites are imported one at a time, so the settings of the store and the policy are those of the current site
+		im.policy = p.policy
+		im.parseMode = p.parseMode
+		store.SetPrivacyPolicy(p.policy.String())
+		if keepsAddresses && rdns != nil {
+			if p.policy.ChangesIP() {
+				store.SetResolver(nil)
+			} else {
+				store.SetResolver(rdns)
+			}
+		}
+		store.SetReferrerClassifier(referrer.NewClassifier(siteDomains(site.Name, strings.Join(site.OwnDomains, ","))))
+		store.SetParamFilter(params.NewFilter(site.Params.Allow, site.Params.Deny))
+		log.Printf("%v: %v files in %v\n", site.Name, len(files), site.LogDir)
+		im.importFiles(files, site.Name, site.LogType, cfg.CPU)
+	}
+	// Close waits for the lookups queued by any site
+	if keepsAddresses && rdns != nil {
+		store.SetResolver(rdns)
+	}
+	return im.finish()
+}
+
+// openConfigStore connects to the log store described in a configuration file
+func openConfigStore(s config.Store) (logstore.LogStore, error) {
+	password, err := s.Password()
+	if err != nil {
+		return nil, fmt.Errorf("store password: %w", err)
+	}
+	if len(password) == 0 {
+		return openStore(s.Driver, s.Connection)
+	}
+	dbconnection, err := withPassword(s.Driver, s.Connection, password)
+	if err != nil {
+		return nil, err
+	}
+	return connectStore(s.Driver, dbconnection)
+}
@@ -0,0 +1,50 @@
+package ipaddr
+
+import (
+	"net/netip"
+)
+
+// Parse parses a client address as it appears in a log, returning it in canonical form:
+// IPv4-mapped IPv6 addresses are reduced to IPv4, and zones are removed
+func Parse(s string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.WithZone("").Unmap(), nil
+}
+
+// Canonical returns the canonical text form of an address, or the text unchanged if it is not a valid address
+func Canonical(s string) string {
+	addr, err := Parse(s)
+	if err != nil {
+		return s
+	}
+	return addr.String()
+}
+
+// Bytes returns the 16 byte form in which addresses are stored, with IPv4 addresses mapped into IPv6.
+// Storing every address at the same length means that a CIDR range is a single range of byte strings.
+func Bytes(addr netip.Addr) []byte {
+	b := addr.As16()
+	return b[:]
+}
+
+// Range returns the lowest and highest stored forms of the addresses in a prefix,
+// for use in queries such as "ip BETWEEN ? AND ?"
+func Range(prefix netip.Prefix) ([]byte, []byte) {
+	if !prefix.IsValid() {
+		return nil, nil
+	}
+	prefix = prefix.Masked()
+	bits := prefix.Bits()
+	if prefix.Addr().Is4() {
+		bits += 96
+	}
+	low := Bytes(prefix.Addr())
+	high := Bytes(prefix.Addr())
+	for i := bits; i < 128; i++ {
+		high[i/8] |= 0x80 >> (i % 8)
+	}
+	return low, high
+}
@@ -0,0 +1,121 @@
+package latency
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// bucketsPerDoubling sets the resolution of the buckets: each is about 9% wider than the one before
+const bucketsPerDoubling = 8
+
+// buckets covers durations up to 2^64 nanoseconds
+const buckets = 64*bucketsPerDoubling + 1
+
+// Histogram keeps an approximate distribution of durations in logarithmic buckets,
+// so that percentiles can be reported without keeping every duration
+type Histogram struct {
+	mutex  *sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    time.Duration
+	max    time.Duration
+}
+
+// Summary is a snapshot of a histogram, with its durations in seconds
+type Summary struct {
+	Count uint64  `json:"count"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+// New creates an empty histogram
+func New() *Histogram {
+	result := Histogram{}
+	result.mutex = &sync.Mutex{}
+	result.counts = make([]uint64, buckets)
+	return &result
+}
+
+// bucket returns the index of the bucket holding a duration
+func bucket(d time.Duration) int {
+	if d <= 1 {
+		return 0
+	}
+	return int(math.Ceil(math.Log2(float64(d)) * bucketsPerDoubling))
+}
+
+// upperBound returns the longest duration held by a bucket
+func upperBound(i int) time.Duration {
+	return time.Duration(math.Exp2(float64(i) / bucketsPerDoubling))
+}
+
+// Add records a duration
+func (h *Histogram) Add(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	i := bucket(d)
+	h.mutex.Lock()
+	h.counts[i]++
+	h.count++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+	h.mutex.Unlock()
+}
+
+// Count reports the number of durations recorded
+func (h *Histogram) Count() uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.count
+}
+
+// Quantile estimates the duration below which a fraction q of the durations fall,
+// to within the width of a bucket; it is 0 for an empty histogram
+func (h *Histogram) Quantile(q float64) time.Duration {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.quantile(q)
+}
+
+func (h *Histogram) quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			// The bound of the last bucket may be beyond the longest duration actually seen
+			if d := upperBound(i); d < h.max {
+				return d
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
+
+// Summarize returns the count, mean, median, 90th and 99th percentiles and maximum of the histogram
+func (h *Histogram) Summarize() Summary {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	result := Summary{Count: h.count, Max: h.max.Seconds()}
+	if h.count > 0 {
+		result.Mean = (h.sum / time.Duration(h.count)).Seconds()
+	}
+	result.P50 = h.quantile(0.5).Seconds()
+	result.P90 = h.quantile(0.9).Seconds()
+	result.P99 = h.quantile(0.99).Seconds()
+	return result
+}
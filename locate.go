@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/infodancer/implog/geoip"
+)
+
+// locateAddresses records the location and network of stored ip addresses from local geoip databases,
+// normally for addresses imported before the databases were configured
+func locateAddresses(args []string) int {
+	flags := newFlagSet("geoip", "-geoip-city file -geoip-asn file [-all] [flags]")
+	stores := storeFlags(flags)
+	geoCity := flags.String("geoip-city", "", "The GeoLite2 or DB-IP city (or country) database")
+	geoASN := flags.String("geoip-asn", "", "The GeoLite2 or DB-IP ASN database")
+	all := flags.Bool("all", false, "Update every address, not just those without a location (for instance after updating the databases)")
+	flags.Parse(args)
+
+	geo, err := geoip.Open(*geoCity, *geoASN)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer geo.Close()
+
+	store, err := stores.open()
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	store.SetGeoIP(geo)
+	err = store.Init(context.Background())
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer store.Close()
+
+	count, err := store.LocateIPAddresses(context.Background(), *all)
+	log.Printf("Located %v addresses\n", count)
+	return exitStatus(count, err)
+}
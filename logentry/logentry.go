@@ -2,7 +2,7 @@ package logentry
 
 // LogEntry refers to a generic entry in a line-based log
 type LogEntry interface {
-	// GetUUID reports a randomly generated UUID for this entry
+	// GetUUID reports a hash of the line this entry was read from, identifying it when read again
 	GetUUID() []byte
 	// IsParseError reports whether this log entry failed to parse correctly
 	IsParseError() bool
@@ -2,11 +2,23 @@ package logstore
 
 import (
 	"context"
+	"errors"
+	"io"
 	"time"
 
+	"github.com/infodancer/implog/geoip"
 	"github.com/infodancer/implog/httplog"
+	"github.com/infodancer/implog/params"
+	"github.com/infodancer/implog/referrer"
+	"github.com/infodancer/implog/report"
+	"github.com/infodancer/implog/resolver"
+	"github.com/infodancer/implog/useragent"
 )
 
+// ErrDuplicate is returned when writing an entry the LogStore recognizes as already stored
+// without the LogStore itself rejecting it
+var ErrDuplicate = errors.New("duplicate entry")
+
 // LogStore defines an interface for storing log entries
 type LogStore interface {
 	// Opens a connection to the LogStore
@@ -15,9 +27,71 @@ type LogStore interface {
 	Ping(ctx context.Context) error
 	// Init initializes the LogStore by creating tables, etc
 	Init(ctx context.Context) error
+	// PrintSchema writes the SQL that creates the tables of the latest schema
+	PrintSchema(w io.Writer) error
+	// SchemaVersion reports the latest migration applied to the LogStore, or 0 if it has not been initialized
+	SchemaVersion(ctx context.Context) (int, error)
+	// Verify checks the consistency of the LogStore, listing the problems found
+	Verify(ctx context.Context) ([]string, error)
+	// SetReadOnly sets whether the LogStore may be changed, before Init; a read-only LogStore writes nothing
+	SetReadOnly(readOnly bool)
 	// WriteHTTPLogEntry writes a single log entry
 	WriteHTTPLogEntry(ctx context.Context, entry httplog.Entry) error
+	// HasEntry reports whether an entry has already been stored
+	HasEntry(ctx context.Context, entry httplog.Entry) (bool, error)
 	LookupLogFile(logfile string, modified time.Time) (string, time.Time, error)
+	// SetResolver sets the resolver used to look up the names of new ip addresses in the background
+	SetResolver(r *resolver.Resolver)
+	// ResolveIPNames queues ip addresses without names for lookup, optionally including those with no name found before
+	ResolveIPNames(ctx context.Context, includeUnknown bool) (int, error)
+	// SetGeoIP sets the databases used to record the location and network of new ip addresses
+	SetGeoIP(db *geoip.DB)
+	// LocateIPAddresses records the location and network of stored ip addresses that have none, or of all of them
+	LocateIPAddresses(ctx context.Context, all bool) (int, error)
+	// SetUserAgentParser sets the parser used to split new user agents into browser, operating system and device
+	SetUserAgentParser(p *useragent.Parser)
+	// ParseClients parses the stored user agents that have not been parsed yet, or all of them
+	ParseClients(ctx context.Context, all bool) (int, error)
+	// SetReferrerClassifier sets the classifier used to split and classify new referrers
+	SetReferrerClassifier(c *referrer.Classifier)
+	// ClassifyReferrers classifies the stored referrers that have not been classified yet, or all of them
+	ClassifyReferrers(ctx context.Context, all bool) (int, error)
+	// Reclassify changes the traffic class of a log's entries from an address from one class to another,
+	// and reports how many were changed
+	Reclassify(ctx context.Context, logname string, address string, from string, to string) (int64, error)
+	// SetParamFilter sets the filter deciding which query string parameters are stored
+	SetParamFilter(f *params.Filter)
+	// SetPrivacyPolicy sets the description of the privacy policy applied to new entries, recorded with each log file
+	SetPrivacyPolicy(policy string)
+	// Sessionize groups the entries of a log (or of every log) that are not yet part of a session into sessions
+	Sessionize(ctx context.Context, logname string, timeout time.Duration, useCookie bool) (int, error)
+	// Report gathers the statistics for a log over a period, with up to limit rows in each top-N section
+	Report(ctx context.Context, filter report.Filter, limit int) (*report.Report, error)
+	// Top returns up to limit rows of one of the top-N tables, such as the most requested URIs
+	Top(ctx context.Context, filter report.Filter, table string, limit int) ([]report.Count, error)
+	// Statuses counts the requests answered with each status code
+	Statuses(ctx context.Context, filter report.Filter) ([]report.Count, error)
+	// Hits counts the requests, bytes sent and distinct addresses in each hour, day or month, from the rollups,
+	// or from the entries when the filter has a CIDR range
+	Hits(ctx context.Context, filter report.Filter, period string) ([]report.Count, error)
+	// RebuildRollups recalculates the hourly and daily rollups of the days covered by a filter from their entries
+	RebuildRollups(ctx context.Context, filter report.Filter) (int, error)
+	// RebuildPendingRollups rebuilds the rollups of the days with entries counted in a batch that was never written
+	RebuildPendingRollups(ctx context.Context) (int, error)
+	// PurgeEntries deletes the entries of a log (or of every log) requested before a time, keeping the rollups
+	PurgeEntries(ctx context.Context, logname string, before time.Time) (int, error)
+	// PurgeOrphans deletes the paths, referrers, addresses and user agents no longer referred to by any entry
+	PurgeOrphans(ctx context.Context) (int, error)
+	// PurgeLogFiles deletes the records of imported log files for which exists returns false
+	PurgeLogFiles(ctx context.Context, exists func(filename string) bool) (int, error)
+	// ExportEntries reads back the entries selected by a filter, passing each to fn
+	ExportEntries(ctx context.Context, filter report.Filter, fn func(Record) error) (int, error)
+	// Stats reports the sizes of the caches and the statistics of the database connections
+	Stats() Stats
+	// SetObserver sets the observer told of the batches of rows written, or nil for none
+	SetObserver(o Observer)
+	// LogNames lists the logs in the store
+	LogNames(ctx context.Context) ([]string, error)
 	// Clear removes existing data from the log store, including tables
 	Clear(ctx context.Context) error
 	// Close closes the log store
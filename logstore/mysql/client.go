@@ -0,0 +1,111 @@
+package mysql
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"errors"
+	"log"
+
+	"github.com/infodancer/implog/useragent"
+)
+
+// SetUserAgentParser sets the parser used to split new user agents into browser, operating system and device.
+// Without one, user agents are stored unparsed until ParseClients is run.
+func (s *LogStore) SetUserAgentParser(p *useragent.Parser) {
+	s.uaParser = p
+}
+
+// lookupClient retrieves the id of the CLIENT row for a user agent, inserting it if necessary.
+// An empty user agent, as in the common log format, has no client and returns an empty id.
+func (s *LogStore) lookupClient(ctx context.Context, w *writeTx, ua string) (string, error) {
+	if ua == "" {
+		return "", nil
+	}
+	s.clientMutex.Lock()
+	r := s.clientcache[ua]
+	s.clientMutex.Unlock()
+	if r != "" {
+		return r, nil
+	}
+	hash := sha1.Sum([]byte(ua))
+	var id string
+	err := w.stmt(ctx, s.selectClient).QueryRowContext(ctx, hash[:]).Scan(&id)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("select err: %v", err)
+			return "", err
+		}
+		args := append([]interface{}{newID(), hash[:], ua}, s.clientArgs(ua)...)
+		id, _, err = w.insertUnique(ctx, s.insertClient, s.selectClient, hash[:], args...)
+		if err != nil {
+			log.Printf("insert err: %v", err)
+			return "", err
+		}
+		w.afterCommit(func() {
+			s.clientMutex.Lock()
+			s.clientcache[ua] = id
+			s.clientMutex.Unlock()
+		})
+		return id, nil
+	}
+	s.clientMutex.Lock()
+	s.clientcache[ua] = id
+	s.clientMutex.Unlock()
+	return id, nil
+}
+
+// clientArgs returns the values of the parsed CLIENT columns for a user agent, which are NULL if there is no parser
+func (s *LogStore) clientArgs(ua string) []interface{} {
+	if s.uaParser == nil {
+		return []interface{}{nil, nil, nil, nil, nil, nil, nil, nil, nil}
+	}
+	c := s.uaParser.Parse(ua)
+	return []interface{}{c.BrowserFamily, nullString(c.BrowserVersion), c.OSFamily, nullString(c.OSVersion),
+		c.DeviceFamily, nullString(c.DeviceBrand), nullString(c.DeviceModel), c.DeviceType, c.IsBot}
+}
+
+// ParseClients parses the stored user agents that have not been parsed yet, or all of them if all is set
+// (for instance after updating the regex database), and reports how many were updated
+func (s *LogStore) ParseClients(ctx context.Context, all bool) (int, error) {
+	if s.uaParser == nil {
+		return 0, errors.New("no user agent parser has been set")
+	}
+	query := "SELECT id, useragent FROM CLIENT WHERE browserfamily IS NULL"
+	if all {
+		query = "SELECT id, useragent FROM CLIENT"
+	}
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	type pending struct {
+		id string
+		ua string
+	}
+	clients := make([]pending, 0)
+	for rows.Next() {
+		var p pending
+		err = rows.Scan(&p.id, &p.ua)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		clients = append(clients, p)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, p := range clients {
+		args := append(s.clientArgs(p.ua), p.id)
+		_, err = s.updateClient.ExecContext(ctx, args...)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
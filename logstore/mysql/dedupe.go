@@ -0,0 +1,155 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/infodancer/implog/hll"
+)
+
+// keyedTables are the tables whose rows are looked up by a key, along with the columns that refer to their ids.
+// Concurrent imports could insert the same key twice before it was made unique.
+var keyedTables = []struct {
+	table      string
+	key        string
+	references []string
+}{
+	{"LOGURI", "urihash", []string{"LOGENTRY.loguri_id", "SESSION.entry_uri_id", "SESSION.exit_uri_id"}},
+	{"LOGREFERRER", "urihash", []string{"LOGENTRY.referrer_id"}},
+	{"CLIENT", "uahash", []string{"LOGENTRY.client_id"}},
+	{"LOGIP", "address", []string{"LOGENTRY.logip_id"}},
+}
+
+// mergeDuplicateKeys merges the rows of the keyed tables that share a key into the first of them, so that the
+// key can be made unique. References to the others are moved to it, along with their path rollups.
+func mergeDuplicateKeys(ctx context.Context, s *LogStore) error {
+	for _, t := range keyedTables {
+		merged, err := duplicateKeys(ctx, s.db, t.table, t.key)
+		if err != nil {
+			return err
+		}
+		if len(merged) == 0 {
+			continue
+		}
+		for duplicate, kept := range merged {
+			for _, ref := range t.references {
+				parts := strings.SplitN(ref, ".", 2)
+				_, err = s.db.ExecContext(ctx, "UPDATE "+parts[0]+" SET "+parts[1]+" = ? WHERE "+parts[1]+" = ?", kept, duplicate)
+				if err != nil {
+					return fmt.Errorf("%v: %w", ref, err)
+				}
+			}
+		}
+		if t.table == "LOGURI" {
+			err = mergePathRollups(ctx, s.db, merged)
+			if err != nil {
+				return err
+			}
+		}
+		for duplicate := range merged {
+			_, err = s.db.ExecContext(ctx, "DELETE FROM "+t.table+" WHERE id = ?", duplicate)
+			if err != nil {
+				return fmt.Errorf("%v: %w", t.table, err)
+			}
+		}
+		log.Printf("Merged %v duplicate rows of %v\n", len(merged), t.table)
+	}
+	return nil
+}
+
+// duplicateKeys maps the ids of the rows of a table that repeat the key of an earlier row to the id of that row
+func duplicateKeys(ctx context.Context, db *sql.DB, table string, key string) (map[string]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT t."+key+", t.id FROM "+table+" t JOIN (SELECT "+key+" FROM "+table+
+		" GROUP BY "+key+" HAVING COUNT(*) > 1) d ON d."+key+" = t."+key+" ORDER BY t."+key+", t.created, t.id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make(map[string]string)
+	var lastKey, kept string
+	for rows.Next() {
+		var k, id string
+		err = rows.Scan(&k, &id)
+		if err != nil {
+			return nil, err
+		}
+		if k != lastKey || kept == "" {
+			lastKey, kept = k, id
+			continue
+		}
+		result[id] = kept
+	}
+	return result, rows.Err()
+}
+
+// mergePathRollups adds the rollups of duplicate paths to those of the paths they were merged into,
+// merging their address sketches, and removes them
+func mergePathRollups(ctx context.Context, db *sql.DB, merged map[string]string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	moved := make(map[rollupKey]*rollupRow)
+	for duplicate, kept := range merged {
+		for _, table := range []string{rollupHour, rollupDay} {
+			err = takePathRollups(ctx, tx, table, duplicate, kept, moved)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = writeRollups(ctx, tx, moved)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// takePathRollups removes the rollups of a path from a table, adding them to rows under another path
+func takePathRollups(ctx context.Context, tx *sql.Tx, table string, uriID string, keptID string, rows map[rollupKey]*rollupRow) error {
+	found, err := tx.QueryContext(ctx, "SELECT logname, period, COALESCE(hits, 0), COALESCE(bytes, 0), COALESCE(status1xx, 0), COALESCE(status2xx, 0), "+
+		"COALESCE(status3xx, 0), COALESCE(status4xx, 0), COALESCE(status5xx, 0), ips FROM "+
+		table+" WHERE loguri_id = ?", []byte(uriID))
+	if err != nil {
+		return err
+	}
+	for found.Next() {
+		var k rollupKey
+		var r rollupRow
+		var period mysql.NullTime
+		var ips []byte
+		err = found.Scan(&k.logname, &period, &r.hits, &r.bytes, &r.statuses[0], &r.statuses[1], &r.statuses[2], &r.statuses[3], &r.statuses[4], &ips)
+		if err != nil {
+			found.Close()
+			return err
+		}
+		k.table, k.period, k.uriID = table, period.Time, keptID
+		row := rows[k]
+		if row == nil {
+			row = &rollupRow{ips: hll.NewDefault()}
+			rows[k] = row
+		}
+		row.hits += r.hits
+		row.bytes += r.bytes
+		for i := range row.statuses {
+			row.statuses[i] += r.statuses[i]
+		}
+		if len(ips) > 0 {
+			sketch := &hll.Sketch{}
+			if sketch.UnmarshalBinary(ips) == nil {
+				row.ips.Merge(sketch)
+			}
+		}
+	}
+	found.Close()
+	if err = found.Err(); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE loguri_id = ?", []byte(uriID))
+	return err
+}
@@ -0,0 +1,50 @@
+package mysql
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/infodancer/implog/logstore"
+	"github.com/infodancer/implog/report"
+)
+
+const exportQuery = "SELECT e.logname, e.requesttime, COALESCE(i.address, ''), COALESCE(e.clientident, ''), COALESCE(e.clientauth, ''), " +
+	"COALESCE(e.requestmethod, ''), COALESCE(u.uri, ''), COALESCE(e.requestprotocol, ''), COALESCE(e.status, 0), COALESCE(e.size, 0), " +
+	"COALESCE(r.uri, ''), COALESCE(c.useragent, ''), COALESCE(e.trafficclass, '') FROM LOGENTRY e " +
+	"LEFT JOIN LOGIP i ON i.id = e.logip_id LEFT JOIN LOGURI u ON u.id = e.loguri_id " +
+	"LEFT JOIN LOGREFERRER r ON r.id = e.referrer_id LEFT JOIN CLIENT c ON c.id = e.client_id " +
+	"WHERE {where} ORDER BY e.logname, e.requesttime"
+
+// ExportEntries reads back the entries selected by a filter in order of log and request time, passing each to fn,
+// and reports how many were read. Entries whose request time is unknown have a zero Time.
+func (s *LogStore) ExportEntries(ctx context.Context, filter report.Filter, fn func(logstore.Record) error) (int, error) {
+	where, args := reportFilter(filter)
+	rows, err := s.db.QueryContext(ctx, strings.Replace(exportQuery, whereFilter, where, 1), args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	count := 0
+	for rows.Next() {
+		var r logstore.Record
+		var requestTime mysql.NullTime
+		err = rows.Scan(&r.LogName, &requestTime, &r.Address, &r.Ident, &r.Auth, &r.Method, &r.Path, &r.Protocol,
+			&r.Status, &r.Size, &r.Referrer, &r.UserAgent, &r.TrafficClass)
+		if err != nil {
+			return count, err
+		}
+		if requestTime.Valid && requestTime.Time.Year() > 1970 {
+			r.Time = requestTime.Time
+		}
+		if r.Referrer == "-" {
+			r.Referrer = ""
+		}
+		err = fn(r)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, rows.Err()
+}
@@ -2,11 +2,13 @@ package mysql
 
 import (
 	"context"
+	"crypto/sha1"
 	"database/sql"
-	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"net"
+	"net/netip"
 	"strings"
 	"sync"
 	"time"
@@ -15,7 +17,14 @@ import (
 	"github.com/go-sql-driver/mysql"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
+	"github.com/infodancer/implog/geoip"
 	"github.com/infodancer/implog/httplog"
+	"github.com/infodancer/implog/ipaddr"
+	"github.com/infodancer/implog/logstore"
+	"github.com/infodancer/implog/params"
+	"github.com/infodancer/implog/referrer"
+	"github.com/infodancer/implog/resolver"
+	"github.com/infodancer/implog/useragent"
 )
 
 // LogStore implements a log store in mysql
@@ -26,38 +35,68 @@ type LogStore struct {
 	ipcMutex        *sync.Mutex
 	uriMutex        *sync.Mutex
 	referMutex      *sync.Mutex
+	clientMutex     *sync.Mutex
 	logfilecache    map[string]string
 	ipcache         map[string]string
 	uricache        map[string]string
 	refercache      map[string]string
+	clientcache     map[string]string
 	insertLogEntry  *sql.Stmt
 	insertLogFile   *sql.Stmt
 	selectLogFile   *sql.Stmt
 	updateLogFile   *sql.Stmt
 	insertIPAddress *sql.Stmt
 	selectIPAddress *sql.Stmt
+	updateIPName    *sql.Stmt
+	updateIPGeo     *sql.Stmt
 	insertURI       *sql.Stmt
 	selectURI       *sql.Stmt
 	insertReferrer  *sql.Stmt
 	selectReferrer  *sql.Stmt
+	insertClient    *sql.Stmt
+	selectClient    *sql.Stmt
+	updateClient    *sql.Stmt
+	insertParam     *sql.Stmt
+	selectEntry     *sql.Stmt
+	insertPending   *sql.Stmt
 	db              *sql.DB
+	resolver        *resolver.Resolver
+	geo             *geoip.DB
+	referrers       *referrer.Classifier
+	uaParser        *useragent.Parser
+	paramFilter     *params.Filter
+	privacy         string
+	legacyBefore    time.Time
+	rollup          *rollups
+	rollupMutex     *sync.Mutex
+	readOnly        bool
+	observer        logstore.Observer
 }
 
 const createTable = "CREATE TABLE IF NOT EXISTS "
 const dropTable = "DROP TABLE IF EXISTS "
 const idField = "id BINARY(16) PRIMARY KEY"
-const createLogFileTable = createTable + "LOGFILE (" + idField + ", filename VARCHAR(255), modified TIMESTAMP, created TIMESTAMP DEFAULT CURRENT_TIMESTAMP)"
-const createLogURITable = createTable + "LOGURI (" + idField + ", uri VARCHAR(255), created TIMESTAMP DEFAULT CURRENT_TIMESTAMP)"
-const createLogIPTable = createTable + "LOGIP (" + idField + ", ip VARCHAR(16), name VARCHAR(255), created TIMESTAMP DEFAULT CURRENT_TIMESTAMP)"
-const createLogReferrerTable = createTable + "LOGREFERRER (" + idField + ", uri VARCHAR(255), created TIMESTAMP DEFAULT CURRENT_TIMESTAMP)"
-const createLogEntryTable = createTable + "LOGENTRY (" + idField + ", logname VARCHAR(255), logfile_id INT, loguri_id INT, ipaddress varchar(16), clientident varchar(255), clientauth varchar(255), clientversion varchar(255), requestmethod VARCHAR(16), requestprotocol VARCHAR(16), size BIGINT, status INT, referrer VARCHAR(255))"
-const createClientTable = createTable + "CLIENT ()"
+const createLogFileTable = createTable + "LOGFILE (" + idField + ", filename VARCHAR(255), modified TIMESTAMP, privacy VARCHAR(255), created TIMESTAMP DEFAULT CURRENT_TIMESTAMP)"
+const createLogURITable = createTable + "LOGURI (" + idField + ", urihash BINARY(20), uri TEXT, created TIMESTAMP DEFAULT CURRENT_TIMESTAMP, UNIQUE INDEX (urihash))"
+const createLogParamTable = createTable + "LOGPARAM (logentry_id BINARY(16), name VARCHAR(255), value TEXT, INDEX (logentry_id), INDEX (name, value(100)))"
+const createLogIPTable = createTable + "LOGIP (" + idField + ", ip VARBINARY(16), address VARCHAR(64), name VARCHAR(255), confirmed BOOLEAN, " + geoFields + ", created TIMESTAMP DEFAULT CURRENT_TIMESTAMP, INDEX (ip), UNIQUE INDEX (address))"
+const geoFields = "country CHAR(2), region VARCHAR(255), city VARCHAR(255), latitude DOUBLE, longitude DOUBLE, asn INT UNSIGNED, organization VARCHAR(255)"
+const createLogReferrerTable = createTable + "LOGREFERRER (" + idField + ", urihash BINARY(20), uri TEXT, " + referrerFields + ", created TIMESTAMP DEFAULT CURRENT_TIMESTAMP, UNIQUE INDEX (urihash), INDEX (host), INDEX (referrerclass))"
+const referrerFields = "scheme VARCHAR(32), host VARCHAR(255), path TEXT, referrerclass VARCHAR(16), searchterms VARCHAR(255)"
+const createLogEntryTable = createTable + "LOGENTRY (id BINARY(16) NOT NULL, logname VARCHAR(255) NOT NULL, logfile_id BINARY(16), loguri_id BINARY(16), logip_id BINARY(16), clientident varchar(255), clientauth varchar(255), client_id BINARY(16), requestmethod VARCHAR(16), requestprotocol VARCHAR(16), size BIGINT, status INT, referrer_id BINARY(16), badfields VARCHAR(255), trafficclass VARCHAR(16), requesttime DATETIME NOT NULL, sessionhash BINARY(20), session_id BINARY(16), PRIMARY KEY (logname, requesttime, id), INDEX (id), INDEX (trafficclass), INDEX (session_id), INDEX (referrer_id), INDEX (loguri_id), INDEX (logip_id), INDEX (client_id))" + partitionClause
+const createClientTable = createTable + "CLIENT (" + idField + ", uahash BINARY(20), useragent TEXT, " + clientFields + ", created TIMESTAMP DEFAULT CURRENT_TIMESTAMP, UNIQUE INDEX (uahash))"
+const clientFields = "browserfamily VARCHAR(255), browserversion VARCHAR(255), osfamily VARCHAR(255), osversion VARCHAR(255), devicefamily VARCHAR(255), devicebrand VARCHAR(255), devicemodel VARCHAR(255), devicetype VARCHAR(16), isbot BOOLEAN"
+const dropClientTable = dropTable + " CLIENT"
 const dropLogFileTable = dropTable + " LOGFILE"
 const dropLogEntryTable = dropTable + " LOGENTRY"
 const dropLogURITable = dropTable + " LOGURI"
+const dropLogParamTable = dropTable + " LOGPARAM"
 const dropLogReferrerTable = dropTable + " LOGREFERRER"
 const dropLogIPTable = dropTable + " LOGIP"
-const insertQuery = "INSERT INTO LOGENTRY(id, logname, logfile_id, loguri_id, ipaddress, clientident, clientauth, clientversion, requestmethod, requestprotocol, size, status, referrer) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?)"
+const insertQuery = "INSERT INTO LOGENTRY(id, logname, logfile_id, loguri_id, logip_id, clientident, clientauth, client_id, requestmethod, requestprotocol, size, status, referrer_id, badfields, trafficclass, requesttime, sessionhash) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)"
+
+// ignoreDuplicate makes an insert do nothing if a row with the same unique key already exists
+const ignoreDuplicate = " ON DUPLICATE KEY UPDATE id = id"
 
 // New defines the connection information for the log store
 func New(dbdriver string, dbconnection string) (*LogStore, error) {
@@ -68,9 +107,23 @@ func New(dbdriver string, dbconnection string) (*LogStore, error) {
 	result.lfcMutex = &sync.Mutex{}
 	result.uriMutex = &sync.Mutex{}
 	result.referMutex = &sync.Mutex{}
+	result.clientMutex = &sync.Mutex{}
+	result.rollup = newRollups()
+	result.rollupMutex = &sync.Mutex{}
 	return &result, nil
 }
 
+// WithPassword returns a connection string with its password set, so that the password need not be
+// written in the connection string itself
+func WithPassword(dbconnection string, password string) (string, error) {
+	cfg, err := mysql.ParseDSN(dbconnection)
+	if err != nil {
+		return "", err
+	}
+	cfg.Passwd = password
+	return cfg.FormatDSN(), nil
+}
+
 // Clear drops the tables used for storing log data, normally so they can be recreated in a new format
 func (s *LogStore) Clear(ctx context.Context) error {
 	var err error
@@ -85,6 +138,26 @@ func (s *LogStore) Clear(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	_, err = s.db.Exec(dropSessionTable)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(dropLogParamTable)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(dropRollupHourTable)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(dropRollupDayTable)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(dropRollupPendingTable)
+	if err != nil {
+		return err
+	}
 	_, err = s.db.Exec(dropLogFileTable)
 	if err != nil {
 		return err
@@ -101,6 +174,14 @@ func (s *LogStore) Clear(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	_, err = s.db.Exec(dropClientTable)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(dropSchemaVersionTable)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -126,17 +207,29 @@ func (s *LogStore) Ping(ctx context.Context) error {
 	return nil
 }
 
-// PrintSchema displays the SQL to create the necessary tables
-func (s *LogStore) PrintSchema() {
-	fmt.Printf("Init: %v\n", createLogFileTable)
-	fmt.Printf("Init: %v\n", createLogURITable)
-	fmt.Printf("Init: %v\n", createLogIPTable)
-	fmt.Printf("Init: %v\n", createLogReferrerTable)
-	fmt.Printf("Init: %v\n", createLogEntryTable)
+// PrintSchema writes the SQL that creates the tables of the latest schema, stamped with every migration
+// as Init stamps a new database, so the tables can be created by hand and then used by implog
+func (s *LogStore) PrintSchema(w io.Writer) error {
+	statements := []string{createLogFileTable, createLogURITable, createLogIPTable, createLogReferrerTable,
+		createClientTable, createLogEntryTable, createLogParamTable, createSessionTable, createRollupHourTable,
+		createRollupDayTable, createRollupPendingTable, createSchemaVersionTable}
+	for _, m := range migrations {
+		statements = append(statements, fmt.Sprintf("INSERT INTO SCHEMAVERSION (version, description) VALUES (%d, '%v')", m.version, m.description))
+	}
+	for _, stmt := range statements {
+		_, err := fmt.Fprintf(w, "%v;\n", stmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Init creates the table structure for storing records, if necessary
 func (s *LogStore) Init(ctx context.Context) error {
+	if s.readOnly {
+		return s.initReadOnly(ctx)
+	}
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		log.Fatal(err)
@@ -146,6 +239,12 @@ func (s *LogStore) Init(ctx context.Context) error {
 	defer tx.Rollback()
 	s.db.SetConnMaxLifetime(0)
 
+	fresh, err := s.isNewDatabase(ctx)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
 	_, err = s.db.Exec(createLogFileTable)
 	if err != nil {
 		fmt.Println(err)
@@ -170,16 +269,65 @@ func (s *LogStore) Init(ctx context.Context) error {
 		return err
 	}
 
+	_, err = s.db.Exec(createClientTable)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
 	_, err = s.db.Exec(createLogEntryTable)
 	if err != nil {
 		fmt.Println(err)
 		return err
 	}
 
+	_, err = s.db.Exec(createLogParamTable)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	_, err = s.db.Exec(createSessionTable)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	_, err = s.db.Exec(createRollupHourTable)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	_, err = s.db.Exec(createRollupDayTable)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	_, err = s.db.Exec(createRollupPendingTable)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	err = s.migrate(ctx, fresh)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	err = s.ensurePartitions(ctx)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
 	s.logfilecache = make(map[string]string)
 	s.ipcache = make(map[string]string)
 	s.uricache = make(map[string]string)
 	s.refercache = make(map[string]string)
+	s.clientcache = make(map[string]string)
 
 	s.selectLogFile, err = s.db.PrepareContext(ctx, "SELECT id,modified FROM LOGFILE WHERE filename = ?")
 	if err != nil {
@@ -187,49 +335,85 @@ func (s *LogStore) Init(ctx context.Context) error {
 		return err
 	}
 
-	s.insertLogFile, err = s.db.PrepareContext(ctx, "INSERT INTO LOGFILE (id, filename, created) VALUES (?,?,?)")
+	s.insertLogFile, err = s.db.PrepareContext(ctx, "INSERT INTO LOGFILE (id, filename, created, privacy) VALUES (?,?,?,?)")
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	s.updateLogFile, err = s.db.PrepareContext(ctx, "UPDATE LOGFILE SET modified = ?, privacy = ? where id = ?")
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	s.selectIPAddress, err = s.db.PrepareContext(ctx, "SELECT id FROM LOGIP WHERE address = ?")
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	s.insertIPAddress, err = s.db.PrepareContext(ctx, "INSERT INTO LOGIP (id, ip, address, country, region, city, latitude, longitude, asn, organization) VALUES (?,?,?,?,?,?,?,?,?,?)"+ignoreDuplicate)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	s.updateIPName, err = s.db.PrepareContext(ctx, "UPDATE LOGIP SET name = ?, confirmed = ? WHERE id = ?")
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	s.updateIPGeo, err = s.db.PrepareContext(ctx, "UPDATE LOGIP SET country = ?, region = ?, city = ?, latitude = ?, longitude = ?, asn = ?, organization = ? WHERE id = ?")
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	s.selectURI, err = s.db.PrepareContext(ctx, "SELECT id FROM LOGURI WHERE urihash = ?")
 	if err != nil {
 		fmt.Println(err)
 		return err
 	}
 
-	s.updateLogFile, err = s.db.PrepareContext(ctx, "UPDATE LOGFILE SET modified = ? where id = ?")
+	s.insertURI, err = s.db.PrepareContext(ctx, "INSERT INTO LOGURI (id, urihash, uri) VALUES (?,?,?)"+ignoreDuplicate)
 	if err != nil {
 		fmt.Println(err)
 		return err
 	}
 
-	s.selectIPAddress, err = s.db.PrepareContext(ctx, "SELECT id FROM LOGIP WHERE ip = ?")
+	s.insertParam, err = s.db.PrepareContext(ctx, "INSERT INTO LOGPARAM (logentry_id, name, value) VALUES (?,?,?)")
 	if err != nil {
 		fmt.Println(err)
 		return err
 	}
 
-	s.insertIPAddress, err = s.db.PrepareContext(ctx, "INSERT INTO LOGIP (id, ip, name) VALUES (?,?,?)")
+	s.selectReferrer, err = s.db.PrepareContext(ctx, "SELECT id FROM LOGREFERRER WHERE urihash = ?")
 	if err != nil {
 		fmt.Println(err)
 		return err
 	}
 
-	s.selectURI, err = s.db.PrepareContext(ctx, "SELECT id FROM LOGURI WHERE uri = ?")
+	s.insertReferrer, err = s.db.PrepareContext(ctx, "INSERT INTO LOGREFERRER (id, urihash, uri, scheme, host, path, referrerclass, searchterms) VALUES (?,?,?,?,?,?,?,?)"+ignoreDuplicate)
 	if err != nil {
 		fmt.Println(err)
 		return err
 	}
 
-	s.insertURI, err = s.db.PrepareContext(ctx, "INSERT INTO LOGURI (id, uri) VALUES (?,?)")
+	s.selectClient, err = s.db.PrepareContext(ctx, "SELECT id FROM CLIENT WHERE uahash = ?")
 	if err != nil {
 		fmt.Println(err)
 		return err
 	}
 
-	s.selectReferrer, err = s.db.PrepareContext(ctx, "SELECT id FROM LOGREFERRER WHERE uri = ?")
+	s.insertClient, err = s.db.PrepareContext(ctx, "INSERT INTO CLIENT (id, uahash, useragent, browserfamily, browserversion, osfamily, osversion, devicefamily, devicebrand, devicemodel, devicetype, isbot) VALUES (?,?,?,?,?,?,?,?,?,?,?,?)"+ignoreDuplicate)
 	if err != nil {
 		fmt.Println(err)
 		return err
 	}
 
-	s.insertReferrer, err = s.db.PrepareContext(ctx, "INSERT INTO LOGREFERRER (id, uri) VALUES (?,?)")
+	s.updateClient, err = s.db.PrepareContext(ctx, "UPDATE CLIENT SET browserfamily = ?, browserversion = ?, osfamily = ?, osversion = ?, devicefamily = ?, devicebrand = ?, devicemodel = ?, devicetype = ?, isbot = ? WHERE id = ?")
 	if err != nil {
 		fmt.Println(err)
 		return err
@@ -241,54 +425,88 @@ func (s *LogStore) Init(ctx context.Context) error {
 		return err
 	}
 
+	s.selectEntry, err = s.db.PrepareContext(ctx, selectEntryQuery)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	err = s.findLegacyEntries(ctx)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	s.insertPending, err = s.db.PrepareContext(ctx, "INSERT IGNORE INTO "+rollupPending+" (logname, day, batch) VALUES (?,?,?)")
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	if s.resolver != nil {
+		s.resolver.Start(s.storeIPName)
+	}
 	return nil
 }
 
-// Close closes the database connection
+// Close closes the database connection, first waiting for any queued reverse lookups to be stored
+// and writing the rollups of entries not yet counted in them
 func (s *LogStore) Close() {
-	s.insertLogEntry.Close()
-	s.selectLogFile.Close()
-	s.insertLogFile.Close()
-	s.selectURI.Close()
-	s.insertURI.Close()
-	s.selectIPAddress.Close()
-	s.insertIPAddress.Close()
-	s.selectReferrer.Close()
-	s.insertReferrer.Close()
-	return
+	if s.resolver != nil {
+		s.resolver.Close()
+	}
+	s.flushRollups(context.Background())
+	// A read-only store of a new database has no statements
+	for _, stmt := range []*sql.Stmt{s.insertLogEntry, s.selectLogFile, s.insertLogFile, s.updateLogFile, s.updateIPName,
+		s.updateIPGeo, s.selectURI, s.insertURI, s.insertParam, s.selectIPAddress, s.insertIPAddress, s.selectReferrer,
+		s.insertReferrer, s.selectClient, s.insertClient, s.updateClient, s.selectEntry, s.insertPending} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
 }
 
-// LookupURI retrieves the file id of a log file
-func (s *LogStore) LookupURI(uri string) (string, error) {
+// lookupURI retrieves the id of a request path, inserting it if necessary.
+// Paths are looked up by a hash, as referrers are, so long paths are neither truncated nor confused with each other.
+func (s *LogStore) lookupURI(ctx context.Context, w *writeTx, uri string) (string, error) {
 	s.uriMutex.Lock()
 	r := s.uricache[uri]
 	s.uriMutex.Unlock()
 	if r != "" {
 		return r, nil
 	}
+	hash := sha1.Sum([]byte(uri))
 	var id string
-	err := s.selectURI.QueryRow(uri).Scan(&id)
+	err := w.stmt(ctx, s.selectURI).QueryRowContext(ctx, hash[:]).Scan(&id)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			id = uuid.New().String()
-			_, err = s.insertURI.Exec(id, uri)
+			id, _, err = w.insertUnique(ctx, s.insertURI, s.selectURI, hash[:], newID(), hash[:], uri)
 			if err != nil {
 				log.Printf("insert err: %v", err)
 				return "", err
 			}
-			s.uriMutex.Lock()
-			s.uricache[uri] = id
-			s.uriMutex.Unlock()
+			w.afterCommit(func() {
+				s.uriMutex.Lock()
+				s.uricache[uri] = id
+				s.uriMutex.Unlock()
+			})
 			return id, nil
 		}
 		log.Printf("select err: %v", err)
 		return "", err
 	}
+	s.uriMutex.Lock()
+	s.uricache[uri] = id
+	s.uriMutex.Unlock()
 	return id, nil
 }
 
 // LookupLogFile retrieves the file id of a log file
 func (s *LogStore) LookupLogFile(logfile string, modified time.Time) (string, time.Time, error) {
+	return s.lookupLogFile(context.Background(), nil, logfile, modified)
+}
+
+func (s *LogStore) lookupLogFile(ctx context.Context, w *writeTx, logfile string, modified time.Time) (string, time.Time, error) {
 	// Because we can handle gzipped log files as input, we consider them without the extension
 	logfile = strings.TrimSuffix(logfile, ".gz")
 	s.lfcMutex.Lock()
@@ -302,19 +520,28 @@ func (s *LogStore) LookupLogFile(logfile string, modified time.Time) (string, ti
 		modified time.Time
 	}
 	var nt mysql.NullTime
-	err := s.selectLogFile.QueryRow(logfile).Scan(&row.id, &nt)
+	err := sql.ErrNoRows
+	if s.selectLogFile != nil {
+		err = w.stmt(ctx, s.selectLogFile).QueryRowContext(ctx, logfile).Scan(&row.id, &nt)
+	}
 	if err != nil {
+		if err == sql.ErrNoRows && s.readOnly {
+			// Reported as the insert below would be, so a dry run skips the same files as an import
+			return "", time.Now().AddDate(0, 0, -1), nil
+		}
 		if err == sql.ErrNoRows {
 			// insert a new record
-			row.id = uuid.New().String()
-			_, err = s.insertLogFile.Exec(row.id, logfile, modified)
+			row.id = newID()
+			_, err = w.stmt(ctx, s.insertLogFile).ExecContext(ctx, row.id, logfile, modified, nullString(s.privacy))
 			if err != nil {
 				log.Printf("insert err: %v", err)
 				return "", modified, err
 			}
-			s.lfcMutex.Lock()
-			s.logfilecache[logfile] = row.id
-			s.lfcMutex.Unlock()
+			w.afterCommit(func() {
+				s.lfcMutex.Lock()
+				s.logfilecache[logfile] = row.id
+				s.lfcMutex.Unlock()
+			})
 
 			// return yesterday's date to ensure the new filw is processed
 			yesterday := time.Now().AddDate(0, 0, -1)
@@ -330,117 +557,365 @@ func (s *LogStore) LookupLogFile(logfile string, modified time.Time) (string, ti
 		row.modified = time.Now().AddDate(0, 0, -1)
 	}
 	// Compare the modified time and update if needed
-	if modified.After(row.modified) {
-		_, err = s.updateLogFile.Exec(modified, logfile)
+	if modified.After(row.modified) && !s.readOnly {
+		_, err = w.stmt(ctx, s.updateLogFile).ExecContext(ctx, modified, nullString(s.privacy), row.id)
 		if err != nil {
 			log.Printf("update err: %v", err)
 			return row.id, row.modified, err
 		}
-		s.lfcMutex.Lock()
-		s.logfilecache[logfile] = row.id
-		s.lfcMutex.Unlock()
+		w.afterCommit(func() {
+			s.lfcMutex.Lock()
+			s.logfilecache[logfile] = row.id
+			s.lfcMutex.Unlock()
+		})
 	}
 
 	return row.id, row.modified, nil
 }
 
-// LookupIPAddress retrieves the uuid for an ip address
-func (s *LogStore) LookupIPAddress(ip string) (string, error) {
+// SetPrivacyPolicy sets the description of the privacy policy applied to new entries, which is recorded with each log file
+func (s *LogStore) SetPrivacyPolicy(policy string) {
+	s.privacy = policy
+}
+
+// lookupIPAddress retrieves the id for an ip address, inserting it if necessary.
+// Addresses are stored in their 16 byte form alongside their canonical text;
+// anything that is not a valid address is stored by its text alone.
+func (s *LogStore) lookupIPAddress(ctx context.Context, w *writeTx, ip string) (string, error) {
+	address := ipaddr.Canonical(ip)
 	s.ipcMutex.Lock()
-	r := s.ipcache[ip]
+	r := s.ipcache[address]
 	s.ipcMutex.Unlock()
 	if r != "" {
 		return r, nil
 	}
 	var id string
-	err := s.selectIPAddress.QueryRow(ip).Scan(&id)
+	err := w.stmt(ctx, s.selectIPAddress).QueryRowContext(ctx, address).Scan(&id)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			id = uuid.New().String()
-			var name string
-			names, err := net.LookupAddr(ip)
-			if err != nil || len(names) < 1 {
-				name = "unknown"
-			} else {
-				name = names[0]
+			var ipBytes []byte
+			var loc *geoip.Location
+			addr, err := ipaddr.Parse(address)
+			if err == nil {
+				ipBytes = ipaddr.Bytes(addr)
+				loc = s.locate(addr)
 			}
-			_, err = s.insertIPAddress.Exec(id, ip, name)
+			args := append([]interface{}{newID(), ipBytes, address}, locationArgs(loc)...)
+			var inserted bool
+			id, inserted, err = w.insertUnique(ctx, s.insertIPAddress, s.selectIPAddress, address, args...)
 			if err != nil {
 				log.Printf("insert err: %v", err)
 				return "", err
 			}
-			s.ipcMutex.Lock()
-			s.ipcache[ip] = id
-			s.ipcMutex.Unlock()
+			w.afterCommit(func() {
+				// Names are filled in later, so a slow resolver never holds up the import
+				if s.resolver != nil && inserted && ipBytes != nil {
+					s.resolver.TryEnqueue(id, address)
+				}
+				s.ipcMutex.Lock()
+				s.ipcache[address] = id
+				s.ipcMutex.Unlock()
+			})
 			return id, nil
 		}
 		log.Printf("select err: %v", err)
 		return "", err
 	}
+	s.ipcMutex.Lock()
+	s.ipcache[address] = id
+	s.ipcMutex.Unlock()
 	return id, nil
 }
 
-// LookupReferrer retrieves the referrer
-func (s *LogStore) LookupReferrer(referrer string) (string, error) {
+// SetResolver sets the resolver used to fill in the names of new ip addresses in the background.
+// Without one, names are left empty until they are resolved with ResolveIPNames.
+func (s *LogStore) SetResolver(r *resolver.Resolver) {
+	s.resolver = r
+}
+
+// Reclassify changes the traffic class of a log's entries from an address from one class to another,
+// such as from an unverified crawler to a verified one once its address has been looked up,
+// and reports how many were changed
+func (s *LogStore) Reclassify(ctx context.Context, logname string, address string, from string, to string) (int64, error) {
+	if s.readOnly {
+		return 0, errReadOnly
+	}
+	res, err := s.db.ExecContext(ctx, "UPDATE LOGENTRY e JOIN LOGIP i ON i.id = e.logip_id SET e.trafficclass = ? "+
+		"WHERE e.logname = ? AND i.address = ? AND e.trafficclass = ?", to, logname, ipaddr.Canonical(address), from)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// storeIPName records the result of a reverse lookup
+func (s *LogStore) storeIPName(id string, address string, result resolver.Result) {
+	_, err := s.updateIPName.Exec(result.Name, result.Confirmed, id)
+	if err != nil {
+		log.Printf("update err for %v: %v", address, err)
+	}
+}
+
+// ResolveIPNames queues every ip address without a name for a reverse lookup, optionally including those
+// previously found to have no name, and reports how many were queued.
+// The lookups are complete once the log store is closed.
+func (s *LogStore) ResolveIPNames(ctx context.Context, includeUnknown bool) (int, error) {
+	if s.resolver == nil {
+		return 0, errors.New("no resolver has been set")
+	}
+	query := "SELECT id, address FROM LOGIP WHERE ip IS NOT NULL AND name IS NULL"
+	if includeUnknown {
+		query = "SELECT id, address FROM LOGIP WHERE ip IS NOT NULL AND (name IS NULL OR name = ?)"
+	}
+	var rows *sql.Rows
+	var err error
+	if includeUnknown {
+		rows, err = s.db.QueryContext(ctx, query, resolver.Unknown)
+	} else {
+		rows, err = s.db.QueryContext(ctx, query)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		var id, address string
+		err = rows.Scan(&id, &address)
+		if err != nil {
+			return count, err
+		}
+		err = s.resolver.Enqueue(ctx, id, address)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, rows.Err()
+}
+
+// SetGeoIP sets the databases used to record the location and network of new ip addresses
+func (s *LogStore) SetGeoIP(db *geoip.DB) {
+	s.geo = db
+}
+
+// locate looks up the location of an address, or returns nil if there are no databases or the lookup fails
+func (s *LogStore) locate(addr netip.Addr) *geoip.Location {
+	if s.geo == nil {
+		return nil
+	}
+	loc, err := s.geo.Lookup(addr)
+	if err != nil {
+		log.Printf("geoip err for %v: %v", addr, err)
+		return nil
+	}
+	return &loc
+}
+
+// locationArgs returns the values of the LOGIP location columns, using NULL for anything unknown
+func locationArgs(loc *geoip.Location) []interface{} {
+	if loc == nil {
+		return []interface{}{nil, nil, nil, nil, nil, nil, nil}
+	}
+	var latitude, longitude, asn interface{}
+	if loc.HasPosition {
+		latitude = loc.Latitude
+		longitude = loc.Longitude
+	}
+	if loc.ASN != 0 {
+		asn = loc.ASN
+	}
+	return []interface{}{nullString(loc.Country), nullString(loc.Region), nullString(loc.City), latitude, longitude, asn, nullString(loc.Organization)}
+}
+
+// nullString returns nil for an empty string, so that it is stored as NULL
+func nullString(v string) interface{} {
+	if v == "" {
+		return nil
+	}
+	return v
+}
+
+// LocateIPAddresses records the location and network of stored ip addresses that have none,
+// or of every address if all is set (for instance after updating the databases), and reports how many were updated
+func (s *LogStore) LocateIPAddresses(ctx context.Context, all bool) (int, error) {
+	if s.geo == nil {
+		return 0, errors.New("no geoip databases have been set")
+	}
+	query := "SELECT id, ip FROM LOGIP WHERE ip IS NOT NULL AND country IS NULL AND asn IS NULL"
+	if all {
+		query = "SELECT id, ip FROM LOGIP WHERE ip IS NOT NULL"
+	}
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	type pending struct {
+		id   string
+		addr netip.Addr
+	}
+	addresses := make([]pending, 0)
+	for rows.Next() {
+		var id string
+		var ip []byte
+		err = rows.Scan(&id, &ip)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		addr, ok := netip.AddrFromSlice(ip)
+		if !ok {
+			continue
+		}
+		addresses = append(addresses, pending{id: id, addr: addr.Unmap()})
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, p := range addresses {
+		loc := s.locate(p.addr)
+		if loc == nil {
+			continue
+		}
+		args := append(locationArgs(loc), p.id)
+		_, err = s.updateIPGeo.ExecContext(ctx, args...)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// lookupReferrer retrieves the id of a referrer, inserting it if necessary.
+// Referrers are looked up by a hash of the full URL, so long URLs are neither truncated nor confused with each other.
+func (s *LogStore) lookupReferrer(ctx context.Context, w *writeTx, referrer string) (string, error) {
 	s.referMutex.Lock()
 	r := s.refercache[referrer]
 	s.referMutex.Unlock()
 	if r != "" {
 		return r, nil
 	}
+	hash := sha1.Sum([]byte(referrer))
 	var id string
-	err := s.selectReferrer.QueryRow(referrer).Scan(&id)
+	err := w.stmt(ctx, s.selectReferrer).QueryRowContext(ctx, hash[:]).Scan(&id)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			id = uuid.New().String()
-			_, err = s.insertReferrer.Exec(id, referrer)
+			args := append([]interface{}{newID(), hash[:], referrer}, s.referrerArgs(referrer)...)
+			id, _, err = w.insertUnique(ctx, s.insertReferrer, s.selectReferrer, hash[:], args...)
 			if err != nil {
 				log.Printf("insert err: %v", err)
 				return "", err
 			}
-			s.referMutex.Lock()
-			s.refercache[referrer] = id
-			s.referMutex.Unlock()
+			w.afterCommit(func() {
+				s.referMutex.Lock()
+				s.refercache[referrer] = id
+				s.referMutex.Unlock()
+			})
 			return id, nil
 		}
 		log.Printf("select err: %v", err)
 		return "", err
 	}
+	s.referMutex.Lock()
+	s.refercache[referrer] = id
+	s.referMutex.Unlock()
 	return id, nil
 }
 
-// WriteHTTPLogEntry writes an http log entry to the log store
+// WriteHTTPLogEntry writes an http log entry to the log store, in one transaction with its parameters
+// and any new rows it refers to, so that a failure leaves nothing of it behind.
+// Entries that were parsed leniently are written with the names of their invalid fields, so they can be counted.
 func (s *LogStore) WriteHTTPLogEntry(ctx context.Context, entry httplog.Entry) error {
-	if entry.IsParseError() {
-		return nil
+	if s.readOnly {
+		return errReadOnly
 	}
-	uuid := base64.URLEncoding.EncodeToString(entry.GetUUID())
-	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	id := entryID(entry)
+	w, err := s.beginWrite(ctx)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	defer tx.Rollback()
-	// log.Printf("UUID: %v", uuid)
+	defer w.rollback()
 
+	stored, err := s.hasLegacyEntry(ctx, w, entry)
+	if err != nil {
+		return err
+	}
+	if stored {
+		return logstore.ErrDuplicate
+	}
 	// Look up logfile (inserting if necessary)
-	fileID, _, err := s.LookupLogFile(entry.GetLogFile(), entry.GetLogFileModified())
-
+	fileID, _, err := s.lookupLogFile(ctx, w, entry.GetLogFile(), entry.GetLogFileModified())
+	if err != nil {
+		return err
+	}
 	// Look up ip address (inserting if necessary)
-	s.LookupIPAddress(entry.GetIPAddress())
-	// Look up URI (inserting if necessary)
-	uriID, err := s.LookupURI(entry.GetRequestURI())
+	ipID, err := s.lookupIPAddress(ctx, w, entry.GetIPAddress())
+	if err != nil {
+		return err
+	}
+	// Look up the path (inserting if necessary); the query string is stored separately in LOGPARAM
+	uriID, err := s.lookupURI(ctx, w, entry.GetRequestPath())
+	if err != nil {
+		return err
+	}
 	// Look up referrer (inserting if necessary)
-	referrerID, err := s.LookupReferrer(entry.GetReferrer())
+	referrerID, err := s.lookupReferrer(ctx, w, entry.GetReferrer())
+	if err != nil {
+		return err
+	}
+	// Look up client (inserting if necessary)
+	clientID, err := s.lookupClient(ctx, w, entry.GetClientVersion())
+	if err != nil {
+		return err
+	}
 	// Insert log itself
-
-	_, err = s.insertLogEntry.ExecContext(ctx, uuid, entry.GetLogName(), fileID, uriID, entry.GetIPAddress(), entry.GetClientIdent(),
-		entry.GetClientAuth(), entry.GetClientVersion(), entry.GetRequestMethod(), entry.GetRequestProtocol(),
-		entry.GetSize(), entry.GetStatus(), referrerID)
+	_, err = w.stmt(ctx, s.insertLogEntry).ExecContext(ctx, id, entry.GetLogName(), fileID, uriID, ipID, entry.GetClientIdent(),
+		entry.GetClientAuth(), nullString(clientID), entry.GetRequestMethod(), entry.GetRequestProtocol(),
+		entry.GetSize(), entry.GetStatus(), referrerID, badFields(entry), nullString(entry.GetTrafficClass()),
+		requestTime(entry.GetTimestamp()), sessionHash(entry.GetSessionCookie()))
+	if err != nil {
+		return err
+	}
+	err = s.writeParams(ctx, w, id, entry.GetRequestParams())
 	if err != nil {
 		return err
 	}
-	tx.Commit()
+	// The rollups count the canonical address, as they do when they are rebuilt from LOGIP
+	return s.commitCounted(ctx, w, entry.GetLogName(), entry.GetTimestamp(), uriID, entry.GetSize(), entry.GetStatus(),
+		ipaddr.Canonical(entry.GetIPAddress()))
+}
 
-	return nil
+// newID returns a new id for a row, ordered by the time it was created so that new rows are added
+// at the end of their table's primary key rather than scattered through it
+func newID() string {
+	id, err := uuid.NewV7()
+	if err != nil {
+		id = uuid.New()
+	}
+	return string(id[:])
+}
+
+// entryID returns the id of an entry: the first 16 bytes of the hash of its line.
+// The key of LOGENTRY is the log name, request time and id, so entries are stored in time order
+// within each log, and a line read again is recognized as a duplicate.
+func entryID(entry httplog.Entry) string {
+	hash := entry.GetUUID()
+	if len(hash) > 16 {
+		hash = hash[:16]
+	}
+	return string(hash)
+}
+
+// badFields lists the invalid fields of an entry for storage, or nil if there are none
+func badFields(entry httplog.Entry) interface{} {
+	if !entry.IsParseError() {
+		return nil
+	}
+	return strings.Join(entry.GetBadFields(), ",")
 }
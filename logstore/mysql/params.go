@@ -0,0 +1,32 @@
+package mysql
+
+import (
+	"context"
+
+	"github.com/infodancer/implog/params"
+)
+
+// SetParamFilter sets the filter deciding which query string parameters are stored.
+// Without one, every parameter is stored.
+func (s *LogStore) SetParamFilter(f *params.Filter) {
+	s.paramFilter = f
+}
+
+// writeParams stores the decoded query string parameters of an entry that the filter allows
+func (s *LogStore) writeParams(ctx context.Context, w *writeTx, entryID string, query string) error {
+	if query == "" {
+		return nil
+	}
+	ps := params.Parse(query)
+	if s.paramFilter != nil {
+		ps = s.paramFilter.Apply(ps)
+	}
+	insert := w.stmt(ctx, s.insertParam)
+	for _, p := range ps {
+		_, err := insert.ExecContext(ctx, entryID, p.Name, p.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
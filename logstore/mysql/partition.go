@@ -0,0 +1,186 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// LOGENTRY is partitioned by month of request time, so that a month can be scanned or dropped on its own.
+// Every unique key of a partitioned table must include the request time, so it cannot be NULL; entries
+// without one are stored at unknownTime, which is kept in a partition of its own. Partitioned InnoDB
+// tables cannot have foreign keys, so LOGENTRY has none.
+const (
+	unknownTime = "1970-01-01 00:00:00"
+	// knownTime selects the entries whose request time is known
+	knownTime            = "requesttime > '" + unknownTime + "'"
+	unknownPartition     = "p0"
+	maxPartition         = "pmax"
+	partitionLayout      = "p200601"
+	partitionClause      = " PARTITION BY RANGE COLUMNS (requesttime) (PARTITION " + unknownPartition + " VALUES LESS THAN ('1970-01-02'), PARTITION " + maxPartition + " VALUES LESS THAN (MAXVALUE))"
+	selectPartitions     = "SELECT partition_name, partition_description FROM information_schema.partitions WHERE table_schema = DATABASE() AND table_name = 'LOGENTRY' AND partition_name IS NOT NULL ORDER BY partition_ordinal_position"
+	selectForeignKeys    = "SELECT constraint_name FROM information_schema.table_constraints WHERE table_schema = DATABASE() AND table_name = 'LOGENTRY' AND constraint_type = 'FOREIGN KEY'"
+	partitionMonthsAhead = 3
+)
+
+// requestTime returns the stored form of a request time, using unknownTime for a zero time
+func requestTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return unknownTime
+	}
+	return t.UTC()
+}
+
+// monthPartition is a partition holding the entries requested before the start of a month
+type monthPartition struct {
+	name string
+	end  time.Time
+}
+
+// monthPartitions lists the monthly partitions of LOGENTRY in order, leaving out those for unknown
+// and future times, and reports whether the table is partitioned at all
+func (s *LogStore) monthPartitions(ctx context.Context) ([]monthPartition, bool, error) {
+	rows, err := s.db.QueryContext(ctx, selectPartitions)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+	result := make([]monthPartition, 0)
+	partitioned := false
+	for rows.Next() {
+		var name string
+		var description sql.NullString
+		err = rows.Scan(&name, &description)
+		if err != nil {
+			return nil, false, err
+		}
+		partitioned = true
+		if name == unknownPartition || name == maxPartition {
+			continue
+		}
+		bound := strings.Trim(description.String, "'")
+		if len(bound) > 10 {
+			bound = bound[:10]
+		}
+		end, err := time.Parse("2006-01-02", bound)
+		if err != nil {
+			return nil, false, fmt.Errorf("partition %v: %w", name, err)
+		}
+		result = append(result, monthPartition{name: name, end: end})
+	}
+	return result, partitioned, rows.Err()
+}
+
+// ensurePartitions adds monthly partitions to LOGENTRY up to partitionMonthsAhead months from now, so that
+// new entries are not all gathered in the last partition. The first monthly partition starts with the month
+// of the earliest entry, or the current month, and also holds anything older.
+func (s *LogStore) ensurePartitions(ctx context.Context) error {
+	existing, partitioned, err := s.monthPartitions(ctx)
+	if err != nil || !partitioned {
+		return err
+	}
+	now := time.Now().UTC()
+	last := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, partitionMonthsAhead+1, 0)
+	var next time.Time
+	if len(existing) > 0 {
+		next = existing[len(existing)-1].end
+	} else {
+		var first sql.NullString
+		err = s.db.QueryRowContext(ctx, "SELECT DATE_FORMAT(MIN(requesttime), '%Y-%m-01') FROM LOGENTRY WHERE "+knownTime).Scan(&first)
+		if err != nil {
+			return err
+		}
+		next = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		if first.Valid {
+			start, err := time.Parse("2006-01-02", first.String)
+			if err == nil && start.Before(next) {
+				next = start
+			}
+		}
+		next = next.AddDate(0, 1, 0)
+	}
+	added := make([]string, 0)
+	for ; !next.After(last); next = next.AddDate(0, 1, 0) {
+		name := next.AddDate(0, -1, 0).Format(partitionLayout)
+		added = append(added, fmt.Sprintf("PARTITION %v VALUES LESS THAN ('%v')", name, next.Format("2006-01-02")))
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	log.Printf("Adding %v monthly partitions to LOGENTRY\n", len(added))
+	_, err = s.db.ExecContext(ctx, "ALTER TABLE LOGENTRY REORGANIZE PARTITION "+maxPartition+" INTO ("+
+		strings.Join(added, ", ")+", PARTITION "+maxPartition+" VALUES LESS THAN (MAXVALUE))")
+	return err
+}
+
+// dropPartitions drops the monthly partitions of LOGENTRY holding only entries requested before a time,
+// after deleting the parameters of their entries, and reports how many entries they held.
+// Partitions hold the entries of every log, so this is only for purging every log at once.
+func (s *LogStore) dropPartitions(ctx context.Context, before time.Time) (int, error) {
+	existing, _, err := s.monthPartitions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, p := range existing {
+		if p.end.After(before) {
+			break
+		}
+		var n int
+		err = s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM LOGENTRY PARTITION ("+p.name+")").Scan(&n)
+		if err != nil {
+			return count, err
+		}
+		for {
+			result, err := s.db.ExecContext(ctx, "DELETE FROM LOGPARAM WHERE logentry_id IN (SELECT id FROM LOGENTRY PARTITION ("+p.name+")) LIMIT ?", purgeBatchSize)
+			if err != nil {
+				return count, err
+			}
+			deleted, err := result.RowsAffected()
+			if err != nil {
+				return count, err
+			}
+			if deleted < purgeBatchSize {
+				break
+			}
+		}
+		_, err = s.db.ExecContext(ctx, "ALTER TABLE LOGENTRY DROP PARTITION "+p.name)
+		if err != nil {
+			return count, err
+		}
+		count += n
+	}
+	return count, nil
+}
+
+// dropLogEntryForeignKeys removes the foreign keys of LOGENTRY, which a partitioned table cannot have
+func dropLogEntryForeignKeys(ctx context.Context, s *LogStore) error {
+	rows, err := s.db.QueryContext(ctx, selectForeignKeys)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, name := range names {
+		_, err = s.db.ExecContext(ctx, "ALTER TABLE LOGENTRY DROP FOREIGN KEY `"+name+"`")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
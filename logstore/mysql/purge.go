@@ -0,0 +1,192 @@
+package mysql
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// purgeBatchSize is the number of rows deleted in each statement, so that no lock is held for long
+const purgeBatchSize = 1000
+
+// orphanQueries delete up to a batch of dimension rows that nothing refers to any more.
+// Paths stay as long as a rollup counts them; every hourly rollup of a path has a daily one.
+var orphanQueries = []string{
+	"DELETE FROM LOGURI WHERE NOT EXISTS (SELECT 1 FROM LOGENTRY e WHERE e.loguri_id = LOGURI.id) " +
+		"AND NOT EXISTS (SELECT 1 FROM " + rollupDay + " r WHERE r.loguri_id = LOGURI.id) LIMIT ?",
+	"DELETE FROM LOGREFERRER WHERE NOT EXISTS (SELECT 1 FROM LOGENTRY e WHERE e.referrer_id = LOGREFERRER.id) LIMIT ?",
+	"DELETE FROM LOGIP WHERE NOT EXISTS (SELECT 1 FROM LOGENTRY e WHERE e.logip_id = LOGIP.id) LIMIT ?",
+	"DELETE FROM CLIENT WHERE NOT EXISTS (SELECT 1 FROM LOGENTRY e WHERE e.client_id = CLIENT.id) LIMIT ?",
+}
+
+// PurgeEntries deletes the entries of a log (or of every log, if logname is empty) requested before a time,
+// along with their parameters and sessions, in batches, and reports how many entries were deleted.
+// Rollups are kept. Entries stored without a request time are never purged.
+// When every log is purged, whole monthly partitions are dropped where they can be.
+func (s *LogStore) PurgeEntries(ctx context.Context, logname string, before time.Time) (int, error) {
+	filter := ""
+	args := []interface{}{before.UTC()}
+	if logname != "" {
+		filter = " AND logname = ?"
+		args = append(args, logname)
+	}
+
+	// Sessions that started before the cutoff go whole; their later entries are left to be grouped again
+	_, err := s.db.ExecContext(ctx, "UPDATE LOGENTRY SET session_id = NULL WHERE session_id IN "+
+		"(SELECT id FROM SESSION WHERE starttime < ? AND endtime >= ?"+filter+")", append([]interface{}{before.UTC()}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		result, err := s.db.ExecContext(ctx, "DELETE FROM SESSION WHERE starttime < ?"+filter+" LIMIT ?", append(args, purgeBatchSize)...)
+		if err != nil {
+			return 0, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		if n < purgeBatchSize {
+			break
+		}
+	}
+
+	count := 0
+	if logname == "" {
+		count, err = s.dropPartitions(ctx, before)
+		if err != nil {
+			return count, err
+		}
+	}
+	for {
+		keys, err := s.entriesBefore(ctx, filter, args)
+		if err != nil {
+			return count, err
+		}
+		if len(keys) == 0 {
+			return count, nil
+		}
+		err = s.deleteEntries(ctx, keys)
+		if err != nil {
+			return count, err
+		}
+		count += len(keys)
+	}
+}
+
+// entryKey is the primary key of an entry. Its id alone is not unique, since the same line may be in several logs.
+type entryKey struct {
+	logname     string
+	requestTime time.Time
+	id          []byte
+}
+
+// entriesBefore returns the keys of up to a batch of entries matching a purge filter
+func (s *LogStore) entriesBefore(ctx context.Context, filter string, args []interface{}) ([]entryKey, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT logname, requesttime, id FROM LOGENTRY WHERE requesttime < ? AND "+knownTime+filter+" LIMIT ?", append(args, purgeBatchSize)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([]entryKey, 0, purgeBatchSize)
+	for rows.Next() {
+		var k entryKey
+		var requestTime mysql.NullTime
+		err = rows.Scan(&k.logname, &requestTime, &k.id)
+		if err != nil {
+			return nil, err
+		}
+		k.requestTime = requestTime.Time
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// deleteEntries deletes a batch of entries, and the parameters of their ids once no entry in another log shares them
+func (s *LogStore) deleteEntries(ctx context.Context, keys []entryKey) error {
+	tuples := make([]string, 0, len(keys))
+	args := make([]interface{}, 0, 3*len(keys))
+	ids := make([]interface{}, 0, len(keys))
+	for _, k := range keys {
+		tuples = append(tuples, "(?,?,?)")
+		args = append(args, k.logname, k.requestTime, k.id)
+		ids = append(ids, k.id)
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, "DELETE FROM LOGENTRY WHERE (logname, requesttime, id) IN ("+strings.Join(tuples, ",")+")", args...)
+	if err != nil {
+		return err
+	}
+	in := "(" + strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",") + ")"
+	_, err = tx.ExecContext(ctx, "DELETE FROM LOGPARAM WHERE logentry_id IN "+in+
+		" AND NOT EXISTS (SELECT 1 FROM LOGENTRY e WHERE e.id = LOGPARAM.logentry_id)", ids...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PurgeOrphans deletes the paths, referrers, addresses and user agents no longer referred to by any entry,
+// in batches, and reports how many rows were deleted.
+// It should not run alongside an import, which may be about to refer to a row again.
+func (s *LogStore) PurgeOrphans(ctx context.Context) (int, error) {
+	count := 0
+	for _, query := range orphanQueries {
+		for {
+			result, err := s.db.ExecContext(ctx, query, purgeBatchSize)
+			if err != nil {
+				return count, err
+			}
+			n, err := result.RowsAffected()
+			if err != nil {
+				return count, err
+			}
+			count += int(n)
+			if n < purgeBatchSize {
+				break
+			}
+		}
+	}
+	return count, nil
+}
+
+// PurgeLogFiles deletes the records of imported log files for which exists returns false, such as files
+// deleted from disk, and reports how many were deleted. A file imported again afterwards is treated as new.
+func (s *LogStore) PurgeLogFiles(ctx context.Context, exists func(filename string) bool) (int, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, filename FROM LOGFILE")
+	if err != nil {
+		return 0, err
+	}
+	missing := make([][]byte, 0)
+	for rows.Next() {
+		var id []byte
+		var filename string
+		err = rows.Scan(&id, &filename)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if !exists(filename) {
+			missing = append(missing, id)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, id := range missing {
+		_, err = s.db.ExecContext(ctx, "DELETE FROM LOGFILE WHERE id = ?", id)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,72 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/infodancer/implog/httplog"
+)
+
+const selectEntryQuery = "SELECT COUNT(*) FROM LOGENTRY WHERE logname = ? AND requesttime = ? AND id = ?"
+
+var errReadOnly = errors.New("the log store is read-only")
+
+// SetReadOnly sets whether the store may be changed, and must be called before Init. A read-only store
+// creates and migrates nothing, records no log files and refuses to write entries, so that an import
+// can be tried against it; a database without tables is treated as empty.
+func (s *LogStore) SetReadOnly(readOnly bool) {
+	s.readOnly = readOnly
+}
+
+// initReadOnly prepares the statements used to look up log files and entries, checking that the schema
+// is up to date so they can be run
+func (s *LogStore) initReadOnly(ctx context.Context) error {
+	s.logfilecache = make(map[string]string)
+	fresh, err := s.isNewDatabase(ctx)
+	if err != nil || fresh {
+		return err
+	}
+	version, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if latest := migrations[len(migrations)-1].version; version != latest {
+		return fmt.Errorf("the schema is at version %v rather than %v; run implog schema migrate first", version, latest)
+	}
+	s.selectLogFile, err = s.db.PrepareContext(ctx, "SELECT id,modified FROM LOGFILE WHERE filename = ?")
+	if err != nil {
+		return err
+	}
+	s.selectEntry, err = s.db.PrepareContext(ctx, selectEntryQuery)
+	if err != nil {
+		return err
+	}
+	return s.findLegacyEntries(ctx)
+}
+
+// HasEntry reports whether an entry has already been stored, as the same line of the same log
+func (s *LogStore) HasEntry(ctx context.Context, entry httplog.Entry) (bool, error) {
+	if s.selectEntry == nil {
+		return false, nil
+	}
+	var count int
+	err := s.selectEntry.QueryRowContext(ctx, entry.GetLogName(), requestTime(entry.GetTimestamp()), entryID(entry)).Scan(&count)
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+	return s.hasLegacyEntry(ctx, nil, entry)
+}
+
+// hasLegacyEntry reports whether an entry requested before migration 15 was stored under the id that earlier
+// versions gave its line. Entries stored then have no request time, so any of the log with that id is taken
+// to be this one, as it was when the id was the key.
+func (s *LogStore) hasLegacyEntry(ctx context.Context, w *writeTx, entry httplog.Entry) (bool, error) {
+	legacy := entry.GetLegacyUUID()
+	if legacy == nil || !entry.GetTimestamp().Before(s.legacyBefore) {
+		return false, nil
+	}
+	var count int
+	err := w.stmt(ctx, s.selectEntry).QueryRowContext(ctx, entry.GetLogName(), unknownTime, string(legacy)).Scan(&count)
+	return count > 0, err
+}
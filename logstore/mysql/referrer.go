@@ -0,0 +1,73 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+
+	"github.com/infodancer/implog/referrer"
+)
+
+// SetReferrerClassifier sets the classifier used to split and classify new referrers.
+// Without one, referrers are stored unclassified until ClassifyReferrers is run.
+func (s *LogStore) SetReferrerClassifier(c *referrer.Classifier) {
+	s.referrers = c
+}
+
+// referrerArgs returns the values of the classified LOGREFERRER columns for a referrer, which are NULL if there is no classifier
+func (s *LogStore) referrerArgs(raw string) []interface{} {
+	if s.referrers == nil {
+		return []interface{}{nil, nil, nil, nil, nil}
+	}
+	r := s.referrers.Classify(raw)
+	return []interface{}{nullString(r.Scheme), nullString(r.Host), nullString(r.Path), r.Class, nullString(r.SearchTerms)}
+}
+
+// ClassifyReferrers classifies the stored referrers that have not been classified yet, or all of them if all is set
+// (for instance after changing the list of the site's own domains), and reports how many were updated
+func (s *LogStore) ClassifyReferrers(ctx context.Context, all bool) (int, error) {
+	if s.referrers == nil {
+		return 0, errors.New("no referrer classifier has been set")
+	}
+	query := "SELECT id, uri FROM LOGREFERRER WHERE referrerclass IS NULL"
+	if all {
+		query = "SELECT id, uri FROM LOGREFERRER"
+	}
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	type pending struct {
+		id  string
+		uri string
+	}
+	referrers := make([]pending, 0)
+	for rows.Next() {
+		var p pending
+		err = rows.Scan(&p.id, &p.uri)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		referrers = append(referrers, p)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	update, err := s.db.PrepareContext(ctx, "UPDATE LOGREFERRER SET scheme = ?, host = ?, path = ?, referrerclass = ?, searchterms = ? WHERE id = ?")
+	if err != nil {
+		return 0, err
+	}
+	defer update.Close()
+	count := 0
+	for _, p := range referrers {
+		args := append(s.referrerArgs(p.uri), p.id)
+		_, err = update.ExecContext(ctx, args...)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,162 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/infodancer/implog/ipaddr"
+	"github.com/infodancer/implog/report"
+)
+
+// whereFilter is replaced in the report queries by the conditions of a report filter
+const whereFilter = "{where}"
+
+// topQueries return the key, detail, hits and bytes of each row of a top-N table
+var topQueries = map[string]string{
+	report.URIs: "SELECT u.uri, '', COUNT(*), COALESCE(SUM(e.size), 0) FROM LOGENTRY e JOIN LOGURI u ON u.id = e.loguri_id " +
+		"WHERE {where} GROUP BY u.id ORDER BY COUNT(*) DESC LIMIT ?",
+	report.Referrers: "SELECT r.uri, COALESCE(r.referrerclass, ''), COUNT(*), COALESCE(SUM(e.size), 0) FROM LOGENTRY e JOIN LOGREFERRER r ON r.id = e.referrer_id " +
+		"WHERE {where} AND r.uri <> '' AND r.uri <> '-' GROUP BY r.id ORDER BY COUNT(*) DESC LIMIT ?",
+	report.Clients: "SELECT i.address, COALESCE(i.name, ''), COUNT(*), COALESCE(SUM(e.size), 0) FROM LOGENTRY e JOIN LOGIP i ON i.id = e.logip_id " +
+		"WHERE {where} GROUP BY i.id ORDER BY COUNT(*) DESC LIMIT ?",
+	report.NotFound: "SELECT u.uri, '', COUNT(*), COALESCE(SUM(e.size), 0) FROM LOGENTRY e JOIN LOGURI u ON u.id = e.loguri_id " +
+		"WHERE {where} AND e.status = 404 GROUP BY u.id ORDER BY COUNT(*) DESC LIMIT ?",
+	report.ServerErrors: "SELECT u.uri, e.status, COUNT(*), COALESCE(SUM(e.size), 0) FROM LOGENTRY e JOIN LOGURI u ON u.id = e.loguri_id " +
+		"WHERE {where} AND e.status BETWEEN 500 AND 599 GROUP BY u.id, e.status ORDER BY COUNT(*) DESC LIMIT ?",
+	// Countries and browsers are only known if addresses were located and user agents parsed
+	report.Countries: "SELECT i.country, '', COUNT(*), COALESCE(SUM(e.size), 0) FROM LOGENTRY e JOIN LOGIP i ON i.id = e.logip_id " +
+		"WHERE {where} AND i.country IS NOT NULL GROUP BY i.country ORDER BY COUNT(*) DESC LIMIT ?",
+	report.Browsers: "SELECT c.browserfamily, '', COUNT(*), COALESCE(SUM(e.size), 0) FROM LOGENTRY e JOIN CLIENT c ON c.id = e.client_id " +
+		"WHERE {where} AND c.browserfamily IS NOT NULL GROUP BY c.browserfamily ORDER BY COUNT(*) DESC LIMIT ?",
+}
+
+// reportFilter returns the conditions on LOGENTRY e for a report filter, and their arguments
+func reportFilter(filter report.Filter) (string, []interface{}) {
+	conditions := []string{"1 = 1"}
+	args := []interface{}{}
+	if filter.LogName != "" {
+		conditions = append(conditions, "e.logname = ?")
+		args = append(args, filter.LogName)
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "e.requesttime >= ?")
+		args = append(args, filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "e.requesttime < ?")
+		args = append(args, filter.To.UTC())
+	}
+	if !filter.From.IsZero() || !filter.To.IsZero() {
+		conditions = append(conditions, "e."+knownTime)
+	}
+	if filter.CIDR.IsValid() {
+		low, high := ipaddr.Range(filter.CIDR)
+		conditions = append(conditions, "e.logip_id IN (SELECT id FROM LOGIP WHERE ip BETWEEN ? AND ?)")
+		args = append(args, low, high)
+	}
+	if filter.Class != "" {
+		conditions = append(conditions, "e.trafficclass = ?")
+		args = append(args, filter.Class)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// Report gathers the statistics for a log over a period, with up to limit rows in each top-N section
+func (s *LogStore) Report(ctx context.Context, filter report.Filter, limit int) (*report.Report, error) {
+	result := report.New(filter)
+	var err error
+	tables := []struct {
+		name string
+		rows *[]report.Count
+	}{
+		{report.URIs, &result.TopURIs},
+		{report.Referrers, &result.TopReferrers},
+		{report.Clients, &result.TopClients},
+		{report.NotFound, &result.NotFound},
+		{report.ServerErrors, &result.ServerErrors},
+		{report.Countries, &result.Countries},
+		{report.Browsers, &result.Browsers},
+	}
+	for _, t := range tables {
+		*t.rows, err = s.Top(ctx, filter, t.name, limit)
+		if err != nil {
+			return nil, err
+		}
+	}
+	result.Statuses, err = s.Statuses(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	result.Bandwidth, err = s.Hits(ctx, filter, report.Day)
+	if err != nil {
+		return nil, err
+	}
+	result.Hours, err = s.Hits(ctx, filter, report.HourOfDay)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Top returns up to limit rows of one of the top-N tables, such as report.URIs
+func (s *LogStore) Top(ctx context.Context, filter report.Filter, table string, limit int) ([]report.Count, error) {
+	query, ok := topQueries[table]
+	if !ok {
+		return nil, fmt.Errorf("unknown table: %v", table)
+	}
+	if limit <= 0 {
+		limit = report.DefaultLimit
+	}
+	where, args := reportFilter(filter)
+	return s.counts(ctx, strings.Replace(query, whereFilter, where, 1), append(args, limit))
+}
+
+// Statuses counts the requests answered with each status code
+func (s *LogStore) Statuses(ctx context.Context, filter report.Filter) ([]report.Count, error) {
+	where, args := reportFilter(filter)
+	return s.counts(ctx, "SELECT e.status, '', COUNT(*), COALESCE(SUM(e.size), 0) FROM LOGENTRY e "+
+		"WHERE "+where+" GROUP BY e.status ORDER BY e.status", args)
+}
+
+// LogNames lists the logs in the store
+func (s *LogStore) LogNames(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT DISTINCT logname FROM LOGENTRY WHERE logname IS NOT NULL ORDER BY logname")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]string, 0)
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, name)
+	}
+	return result, rows.Err()
+}
+
+// counts runs a query returning key, detail, hits and bytes columns
+func (s *LogStore) counts(ctx context.Context, query string, args []interface{}) ([]report.Count, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]report.Count, 0)
+	for rows.Next() {
+		var key, detail sql.NullString
+		var c report.Count
+		err = rows.Scan(&key, &detail, &c.Hits, &c.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		c.Key = key.String
+		c.Detail = detail.String
+		result = append(result, c)
+	}
+	return result, rows.Err()
+}
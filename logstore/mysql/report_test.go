@@ -0,0 +1,22 @@
+package mysql
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/infodancer/implog/report"
+)
+
+func TestReportFilterClass(t *testing.T) {
+	where, args := reportFilter(report.Filter{LogName: "www.example.com", Class: "human"})
+	if !strings.Contains(where, "e.trafficclass = ?") {
+		t.Errorf("conditions %q do not select the traffic class", where)
+	}
+	if len(args) != 2 || args[1] != "human" {
+		t.Errorf("arguments %v, want the log name and the class", args)
+	}
+	where, args = reportFilter(report.Filter{LogName: "www.example.com"})
+	if strings.Contains(where, "trafficclass") || len(args) != 1 {
+		t.Errorf("conditions %q with %v select a traffic class that was not given", where, args)
+	}
+}
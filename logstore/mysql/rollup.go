@@ -0,0 +1,539 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/infodancer/implog/hll"
+	"github.com/infodancer/implog/report"
+)
+
+// Rollup tables, holding the totals of each log, and of each path within it, per hour and per day
+const (
+	rollupHour = "ROLLUPHOUR"
+	rollupDay  = "ROLLUPDAY"
+)
+
+const rollupFields = " (logname VARCHAR(255) NOT NULL, period DATETIME NOT NULL, loguri_id BINARY(16) NOT NULL, hits BIGINT, bytes BIGINT, " +
+	"status1xx BIGINT, status2xx BIGINT, status3xx BIGINT, status4xx BIGINT, status5xx BIGINT, ips BLOB, PRIMARY KEY (logname, period, loguri_id), INDEX (loguri_id))"
+const createRollupHourTable = createTable + rollupHour + rollupFields
+const createRollupDayTable = createTable + rollupDay + rollupFields
+const dropRollupHourTable = dropTable + " " + rollupHour
+const dropRollupDayTable = dropTable + " " + rollupDay
+
+// rollupPending marks the days of each log with entries that have been stored but are only counted in memory,
+// by the batch counting them, so that their rollups can be rebuilt if the batch is never written
+const rollupPending = "ROLLUPPENDING"
+const createRollupPendingTable = createTable + rollupPending + " (logname VARCHAR(255) NOT NULL, day DATETIME NOT NULL, batch BINARY(16) NOT NULL, PRIMARY KEY (logname, day, batch))"
+const dropRollupPendingTable = dropTable + " " + rollupPending
+const upsertRollupQuery = " (logname, period, loguri_id, hits, bytes, status1xx, status2xx, status3xx, status4xx, status5xx, ips) VALUES (?,?,?,?,?,?,?,?,?,?,?) " +
+	"ON DUPLICATE KEY UPDATE hits = hits + VALUES(hits), bytes = bytes + VALUES(bytes), status1xx = status1xx + VALUES(status1xx), status2xx = status2xx + VALUES(status2xx), " +
+	"status3xx = status3xx + VALUES(status3xx), status4xx = status4xx + VALUES(status4xx), status5xx = status5xx + VALUES(status5xx), ips = VALUES(ips)"
+
+// rollupBatchSize is the number of entries counted in memory before the rollups are written
+const rollupBatchSize = 10000
+
+// wholeLog is the loguri_id of the rows counting every path of a log
+var wholeLog = string(make([]byte, 16))
+
+// rollupKey identifies a row of a rollup table
+type rollupKey struct {
+	table   string
+	logname string
+	period  time.Time
+	uriID   string
+}
+
+// rollupRow holds the totals of a rollup row, or the amounts to add to them
+type rollupRow struct {
+	hits     int64
+	bytes    int64
+	statuses [5]int64
+	ips      *hll.Sketch
+}
+
+// pendingDay is a day of a log marked as having entries counted in the current batch
+type pendingDay struct {
+	logname string
+	day     time.Time
+}
+
+// rollups counts entries in memory until they are added to the rollup tables
+type rollups struct {
+	mutex *sync.Mutex
+	// taking is held while the rows are taken, and read locked from when an entry's day is marked as pending
+	// until the entry is counted, so that the entry is always counted in the batch its day was marked for
+	taking  *sync.RWMutex
+	rows    map[rollupKey]*rollupRow
+	entries int
+	// batch identifies the rows being counted, and marked holds the days marked as pending for them
+	batch  string
+	marked map[pendingDay]bool
+}
+
+func newRollups() *rollups {
+	result := rollups{}
+	result.mutex = &sync.Mutex{}
+	result.taking = &sync.RWMutex{}
+	result.rows = make(map[rollupKey]*rollupRow)
+	result.batch = newID()
+	result.marked = make(map[pendingDay]bool)
+	return &result
+}
+
+// add counts an entry in the hour and day it was requested, for its log and its path (if it has one),
+// and reports how many entries have been counted since the rollups were last taken
+func (r *rollups) add(logname string, t time.Time, uriID string, size int64, status int64, address string) int {
+	hour := t.UTC().Truncate(time.Hour)
+	day := startOfDay(t)
+	keys := []rollupKey{{rollupHour, logname, hour, wholeLog}, {rollupDay, logname, day, wholeLog}}
+	if uriID != "" {
+		keys = append(keys, rollupKey{rollupHour, logname, hour, uriID}, rollupKey{rollupDay, logname, day, uriID})
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, key := range keys {
+		row := r.rows[key]
+		if row == nil {
+			row = &rollupRow{ips: hll.NewDefault()}
+			r.rows[key] = row
+		}
+		row.hits++
+		row.bytes += size
+		if status >= 100 && status < 600 {
+			row.statuses[status/100-1]++
+		}
+		row.ips.AddString(address)
+	}
+	r.entries++
+	return r.entries
+}
+
+// take removes and returns the rows counted so far, and the batch that counted them
+func (r *rollups) take() (map[rollupKey]*rollupRow, string) {
+	r.taking.Lock()
+	defer r.taking.Unlock()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	rows, batch := r.rows, r.batch
+	r.rows = make(map[rollupKey]*rollupRow)
+	r.entries = 0
+	r.batch = newID()
+	r.marked = make(map[pendingDay]bool)
+	return rows, batch
+}
+
+// commitCounted commits the transaction writing an entry and counts the entry towards the rollups,
+// writing them once enough entries have been counted. The transaction also marks the entry's day as pending
+// for the current batch, unless it already is, so that if the batch is never written, as when the import
+// is killed, the day is rebuilt by RebuildPendingRollups.
+func (s *LogStore) commitCounted(ctx context.Context, w *writeTx, logname string, t time.Time, uriID string, size int64, status int64, address string) error {
+	if t.IsZero() {
+		return w.commit()
+	}
+	r := s.rollup
+	r.taking.RLock()
+	err := s.markPending(ctx, w, pendingDay{logname, startOfDay(t)})
+	if err == nil {
+		err = w.commit()
+	}
+	counted := 0
+	if err == nil {
+		counted = r.add(logname, t, uriID, size, status, address)
+	}
+	r.taking.RUnlock()
+	if err != nil {
+		return err
+	}
+	if counted >= rollupBatchSize {
+		s.flushRollups(ctx)
+	}
+	return nil
+}
+
+// markPending marks a day as pending for the current batch in the transaction writing an entry;
+// the caller must hold the read lock on taking
+func (s *LogStore) markPending(ctx context.Context, w *writeTx, day pendingDay) error {
+	r := s.rollup
+	r.mutex.Lock()
+	batch, marked := r.batch, r.marked[day]
+	r.mutex.Unlock()
+	if marked {
+		return nil
+	}
+	_, err := w.stmt(ctx, s.insertPending).ExecContext(ctx, day.logname, day.day, []byte(batch))
+	if err != nil {
+		return err
+	}
+	w.afterCommit(func() {
+		r.mutex.Lock()
+		r.marked[day] = true
+		r.mutex.Unlock()
+	})
+	return nil
+}
+
+// flushRollups adds the entries counted in memory to the rollup tables, and removes the marks of their days.
+// Failures are logged rather than returned, since the entries themselves have been stored;
+// the marks are then left, so the rollups can be recalculated from them with RebuildPendingRollups.
+func (s *LogStore) flushRollups(ctx context.Context) {
+	rows, batch := s.rollup.take()
+	if len(rows) == 0 {
+		return
+	}
+	s.rollupMutex.Lock()
+	defer s.rollupMutex.Unlock()
+	start := time.Now()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("rollup err: %v", err)
+		return
+	}
+	defer tx.Rollback()
+	err = writeRollups(ctx, tx, rows)
+	if err == nil {
+		_, err = tx.ExecContext(ctx, "DELETE FROM "+rollupPending+" WHERE batch = ?", []byte(batch))
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("rollup err: %v; run implog rollup rebuild -pending to correct the rollups", err)
+		return
+	}
+	if s.observer != nil {
+		s.observer.ObserveBatch("rollup", len(rows), time.Since(start))
+	}
+}
+
+// writeRollups adds rows to the rollup tables, merging the address sketches with those already stored.
+// Rows are written in key order so that concurrent writers lock them in the same order.
+func writeRollups(ctx context.Context, tx *sql.Tx, rows map[rollupKey]*rollupRow) error {
+	keys := make([]rollupKey, 0, len(rows))
+	for k := range rows {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.table != b.table {
+			return a.table < b.table
+		}
+		if a.logname != b.logname {
+			return a.logname < b.logname
+		}
+		if !a.period.Equal(b.period) {
+			return a.period.Before(b.period)
+		}
+		return a.uriID < b.uriID
+	})
+	statements := make(map[string][2]*sql.Stmt)
+	for _, table := range []string{rollupHour, rollupDay} {
+		sel, err := tx.PrepareContext(ctx, "SELECT ips FROM "+table+" WHERE logname = ? AND period = ? AND loguri_id = ? FOR UPDATE")
+		if err != nil {
+			return err
+		}
+		defer sel.Close()
+		upsert, err := tx.PrepareContext(ctx, "INSERT INTO "+table+upsertRollupQuery)
+		if err != nil {
+			return err
+		}
+		defer upsert.Close()
+		statements[table] = [2]*sql.Stmt{sel, upsert}
+	}
+	for _, k := range keys {
+		row := rows[k]
+		sel, upsert := statements[k.table][0], statements[k.table][1]
+		var stored []byte
+		err := sel.QueryRowContext(ctx, k.logname, k.period, []byte(k.uriID)).Scan(&stored)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if len(stored) > 0 {
+			prev := &hll.Sketch{}
+			err = prev.UnmarshalBinary(stored)
+			if err != nil {
+				return fmt.Errorf("%v %v: %w", k.table, k.period, err)
+			}
+			err = row.ips.Merge(prev)
+			if err != nil {
+				return err
+			}
+		}
+		ips, err := row.ips.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		_, err = upsert.ExecContext(ctx, k.logname, k.period, []byte(k.uriID), row.hits, row.bytes,
+			row.statuses[0], row.statuses[1], row.statuses[2], row.statuses[3], row.statuses[4], ips)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RebuildRollups recalculates the rollups of a log (or of every log, if the filter has no log name)
+// from its entries, a whole day at a time, and reports how many days were rebuilt.
+// Every day overlapping the filter's range that still has entries is rebuilt, so the rollups can be filled in
+// for entries imported before they existed, while those of purged days are kept.
+// Entries imported while a day is being rebuilt may be counted twice.
+func (s *LogStore) RebuildRollups(ctx context.Context, filter report.Filter) (int, error) {
+	if filter.CIDR.IsValid() {
+		return 0, fmt.Errorf("rollups count every address, so they cannot be rebuilt for %v alone", filter.CIDR)
+	}
+	if filter.Class != "" {
+		return 0, fmt.Errorf("rollups count every traffic class, so they cannot be rebuilt for %v alone", filter.Class)
+	}
+	if !filter.From.IsZero() {
+		filter.From = startOfDay(filter.From)
+	}
+	where, args := reportFilter(filter)
+	rows, err := s.db.QueryContext(ctx, "SELECT DISTINCT DATE(e.requesttime) FROM LOGENTRY e WHERE "+where+" AND e."+knownTime+" ORDER BY 1", args...)
+	if err != nil {
+		return 0, err
+	}
+	found := make([]time.Time, 0)
+	for rows.Next() {
+		var day mysql.NullTime
+		err = rows.Scan(&day)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		found = append(found, day.Time)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, day := range found {
+		err = s.rebuildDay(ctx, filter.LogName, day)
+		if err != nil {
+			return count, fmt.Errorf("%v: %w", day.Format("2006-01-02"), err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// RebuildPendingRollups rebuilds the days of each log marked as having entries counted in a batch that was never
+// written, normally because an import was killed, and reports how many were rebuilt. The days marked by an import
+// that is still running are rebuilt too, and may then be counted twice.
+func (s *LogStore) RebuildPendingRollups(ctx context.Context) (int, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT DISTINCT logname, day FROM "+rollupPending+" ORDER BY day, logname")
+	if err != nil {
+		return 0, err
+	}
+	found := make([]pendingDay, 0)
+	for rows.Next() {
+		var p pendingDay
+		var day mysql.NullTime
+		err = rows.Scan(&p.logname, &day)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		p.day = day.Time
+		found = append(found, p)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, p := range found {
+		err = s.rebuildDay(ctx, p.logname, p.day)
+		if err != nil {
+			return count, fmt.Errorf("%v %v: %w", p.logname, p.day.Format("2006-01-02"), err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// rebuildDay replaces the rollups of one day with totals counted from its entries
+func (s *LogStore) rebuildDay(ctx context.Context, logname string, day time.Time) error {
+	day = startOfDay(day)
+	next := day.AddDate(0, 0, 1)
+	entryFilter, rollupFilter := "", ""
+	args := []interface{}{day, next}
+	if logname != "" {
+		entryFilter, rollupFilter = " AND e.logname = ?", " AND logname = ?"
+		args = append(args, logname)
+	}
+
+	counted := newRollups()
+	rows, err := s.db.QueryContext(ctx, "SELECT e.logname, e.requesttime, e.loguri_id, e.size, e.status, COALESCE(i.address, '') FROM LOGENTRY e "+
+		"LEFT JOIN LOGIP i ON i.id = e.logip_id WHERE e.requesttime >= ? AND e.requesttime < ?"+entryFilter, args...)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name, address sql.NullString
+		var requestTime mysql.NullTime
+		var uriID []byte
+		var size, status sql.NullInt64
+		err = rows.Scan(&name, &requestTime, &uriID, &size, &status, &address)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		counted.add(name.String, requestTime.Time, string(uriID), size.Int64, status.Int64, address.String)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	s.rollupMutex.Lock()
+	defer s.rollupMutex.Unlock()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, "DELETE FROM "+rollupHour+" WHERE period >= ? AND period < ?"+rollupFilter, args...)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM "+rollupDay+" WHERE period >= ? AND period < ?"+rollupFilter, args...)
+	if err != nil {
+		return err
+	}
+	// Every entry of the day is counted, including those of batches that were never written
+	_, err = tx.ExecContext(ctx, "DELETE FROM "+rollupPending+" WHERE day >= ? AND day < ?"+rollupFilter, args...)
+	if err != nil {
+		return err
+	}
+	counts, _ := counted.take()
+	err = writeRollups(ctx, tx, counts)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// startOfDay returns midnight UTC at the start of the day of a time
+func startOfDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// rollupPeriods are the rollup table read for each period, and how its rows are grouped,
+// as a time layout for rollups and as a DATE_FORMAT format for entries
+var rollupPeriods = map[string]struct {
+	table  string
+	layout string
+	format string
+}{
+	report.Hour:      {rollupHour, "2006-01-02 15:00", "%Y-%m-%d %H:00"},
+	report.Day:       {rollupDay, "2006-01-02", "%Y-%m-%d"},
+	report.Month:     {rollupDay, "2006-01", "%Y-%m"},
+	report.HourOfDay: {rollupHour, "15", "%H"},
+}
+
+// Hits counts the requests, bytes sent and distinct addresses in each hour, day or month, or each hour
+// of the day, in order, from the rollups. Periods without requests are left out.
+// Rollups cover whole hours or days, so a range that starts or ends within one includes all of it.
+// Rollups do not record addresses or traffic classes, so with a CIDR or class filter the entries themselves are counted instead.
+func (s *LogStore) Hits(ctx context.Context, filter report.Filter, period string) ([]report.Count, error) {
+	p, ok := rollupPeriods[period]
+	if !ok {
+		return nil, fmt.Errorf("unknown period: %v", period)
+	}
+	if filter.CIDR.IsValid() || filter.Class != "" {
+		return s.entryHits(ctx, filter, p.format)
+	}
+	conditions := []string{"loguri_id = ?"}
+	args := []interface{}{[]byte(wholeLog)}
+	if filter.LogName != "" {
+		conditions = append(conditions, "logname = ?")
+		args = append(args, filter.LogName)
+	}
+	if !filter.From.IsZero() {
+		from := filter.From.UTC().Truncate(time.Hour)
+		if p.table == rollupDay {
+			from = startOfDay(from)
+		}
+		conditions = append(conditions, "period >= ?")
+		args = append(args, from)
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "period < ?")
+		args = append(args, filter.To.UTC())
+	}
+	rows, err := s.db.QueryContext(ctx, "SELECT period, hits, bytes, ips FROM "+p.table+" WHERE "+strings.Join(conditions, " AND "), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := make(map[string]*report.Count)
+	sketches := make(map[string]*hll.Sketch)
+	for rows.Next() {
+		var t mysql.NullTime
+		var hits, size sql.NullInt64
+		var ips []byte
+		err = rows.Scan(&t, &hits, &size, &ips)
+		if err != nil {
+			return nil, err
+		}
+		key := t.Time.Format(p.layout)
+		c := totals[key]
+		if c == nil {
+			c = &report.Count{Key: key}
+			totals[key] = c
+			sketches[key] = hll.NewDefault()
+		}
+		c.Hits += hits.Int64
+		c.Bytes += size.Int64
+		if len(ips) > 0 {
+			sketch := &hll.Sketch{}
+			if sketch.UnmarshalBinary(ips) == nil {
+				sketches[key].Merge(sketch)
+			}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	result := make([]report.Count, 0, len(totals))
+	for key, c := range totals {
+		c.UniqueIPs = int64(sketches[key].Estimate())
+		result = append(result, *c)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result, nil
+}
+
+// entryHits counts the requests, bytes sent and distinct addresses of the entries matching a filter,
+// grouped by their request time in a DATE_FORMAT format
+func (s *LogStore) entryHits(ctx context.Context, filter report.Filter, format string) ([]report.Count, error) {
+	where, args := reportFilter(filter)
+	rows, err := s.db.QueryContext(ctx, "SELECT DATE_FORMAT(e.requesttime, '"+format+"'), COUNT(*), COALESCE(SUM(e.size), 0), COUNT(DISTINCT e.logip_id) "+
+		"FROM LOGENTRY e WHERE "+where+" AND e."+knownTime+" GROUP BY 1 ORDER BY 1", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]report.Count, 0)
+	for rows.Next() {
+		var c report.Count
+		err = rows.Scan(&c.Key, &c.Hits, &c.Bytes, &c.UniqueIPs)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, c)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,253 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/infodancer/implog/ipaddr"
+)
+
+const createSchemaVersionTable = createTable + "SCHEMAVERSION (version INT PRIMARY KEY, description VARCHAR(255), applied TIMESTAMP DEFAULT CURRENT_TIMESTAMP)"
+const dropSchemaVersionTable = dropTable + " SCHEMAVERSION"
+
+// migration upgrades a database created by an earlier version of the schema.
+// The create statements always describe the latest schema, so new databases are stamped with every version
+// instead of having the migrations applied.
+type migration struct {
+	version     int
+	description string
+	statements  []string
+}
+
+// preparations run before the statements of a migration, for changes that depend on the state of the database
+var preparations = map[int]func(ctx context.Context, s *LogStore) error{
+	// Foreign keys are named by the server, so they are looked up before being dropped
+	14: dropLogEntryForeignKeys,
+	16: canonicalizeAddresses,
+	17: mergeDuplicateKeys,
+}
+
+var migrations = []migration{
+	{1, "record fields that failed validation", []string{
+		"ALTER TABLE LOGENTRY ADD COLUMN badfields VARCHAR(255)",
+	}},
+	{2, "store ip addresses in binary form and link entries to LOGIP", []string{
+		"ALTER TABLE LOGIP ADD COLUMN address VARCHAR(64), ADD COLUMN ipbinary VARBINARY(16)",
+		"UPDATE LOGIP SET address = ip, ipbinary = INET6_ATON(ip)",
+		"UPDATE LOGIP SET ipbinary = CONCAT(UNHEX('00000000000000000000FFFF'), ipbinary) WHERE LENGTH(ipbinary) = 4",
+		"ALTER TABLE LOGIP DROP COLUMN ip, CHANGE COLUMN ipbinary ip VARBINARY(16), ADD INDEX (ip), ADD INDEX (address)",
+		"ALTER TABLE LOGENTRY ADD COLUMN logip_id BINARY(16)",
+		"UPDATE LOGENTRY e JOIN LOGIP i ON i.address = e.ipaddress SET e.logip_id = i.id",
+		"ALTER TABLE LOGENTRY DROP COLUMN ipaddress, ADD FOREIGN KEY (logip_id) REFERENCES LOGIP (id)",
+	}},
+	{3, "resolve ip names in the background with forward confirmation", []string{
+		"ALTER TABLE LOGIP ADD COLUMN confirmed BOOLEAN",
+	}},
+	{4, "record the location and network of ip addresses", []string{
+		"ALTER TABLE LOGIP ADD COLUMN country CHAR(2), ADD COLUMN region VARCHAR(255), ADD COLUMN city VARCHAR(255), ADD COLUMN latitude DOUBLE, ADD COLUMN longitude DOUBLE, ADD COLUMN asn INT UNSIGNED, ADD COLUMN organization VARCHAR(255)",
+	}},
+	{5, "move user agents into the CLIENT table", []string{
+		// The user agents are parsed afterwards with implog useragents
+		"INSERT INTO CLIENT (id, uahash, useragent) SELECT UNHEX(REPLACE(UUID(), '-', '')), UNHEX(SHA1(clientversion)), clientversion FROM LOGENTRY WHERE clientversion IS NOT NULL AND clientversion <> '' GROUP BY clientversion",
+		"ALTER TABLE LOGENTRY ADD COLUMN client_id BINARY(16)",
+		"UPDATE LOGENTRY e JOIN CLIENT c ON c.uahash = UNHEX(SHA1(e.clientversion)) SET e.client_id = c.id",
+		"ALTER TABLE LOGENTRY DROP COLUMN clientversion, ADD FOREIGN KEY (client_id) REFERENCES CLIENT (id)",
+	}},
+	{6, "classify traffic as human, crawler, scanner and so on", []string{
+		"ALTER TABLE LOGENTRY ADD COLUMN trafficclass VARCHAR(16), ADD INDEX (trafficclass)",
+	}},
+	{7, "store full referrers split into their parts and classified", []string{
+		// Existing referrers are classified afterwards with implog referrers
+		"ALTER TABLE LOGREFERRER MODIFY uri TEXT, ADD COLUMN urihash BINARY(20), ADD COLUMN scheme VARCHAR(32), ADD COLUMN host VARCHAR(255), ADD COLUMN path TEXT, ADD COLUMN referrerclass VARCHAR(16), ADD COLUMN searchterms VARCHAR(255)",
+		"UPDATE LOGREFERRER SET urihash = UNHEX(SHA1(uri))",
+		"ALTER TABLE LOGREFERRER ADD INDEX (urihash), ADD INDEX (host), ADD INDEX (referrerclass)",
+	}},
+	{8, "store request paths without their query strings, which go into LOGPARAM", []string{
+		// Existing URIs keep their query strings; new entries refer to paths alone
+		"ALTER TABLE LOGURI MODIFY uri TEXT, ADD COLUMN urihash BINARY(20)",
+		"UPDATE LOGURI SET urihash = UNHEX(SHA1(uri))",
+		"ALTER TABLE LOGURI ADD INDEX (urihash)",
+		// loguri_id was an INT, into which the text of each LOGURI id was converted as a number: its leading
+		// digits, or 0 for the many ids that start with a letter. Those match no path, or the wrong one, so they
+		// are dropped; implog verify counts any entries left referring to missing paths
+		"UPDATE LOGENTRY SET loguri_id = NULL",
+		"ALTER TABLE LOGENTRY MODIFY loguri_id BINARY(16)",
+	}},
+	{9, "record the privacy policy applied to each log file", []string{
+		"ALTER TABLE LOGFILE ADD COLUMN privacy VARCHAR(255)",
+	}},
+	{10, "record request times and session cookies so entries can be grouped into sessions", []string{
+		// Entries stored before this have no request time and are left out of sessions
+		"ALTER TABLE LOGENTRY ADD COLUMN requesttime DATETIME, ADD COLUMN sessionhash BINARY(20), ADD COLUMN session_id BINARY(16), ADD INDEX (logname, requesttime), ADD INDEX (session_id)",
+	}},
+	{11, "link entries to LOGREFERRER by binary id", []string{
+		// The referrer column held the text form of the id, of which LOGREFERRER kept the first 16 characters
+		"ALTER TABLE LOGENTRY ADD COLUMN referrer_id BINARY(16)",
+		"UPDATE LOGENTRY e JOIN LOGREFERRER r ON r.id = CAST(LEFT(e.referrer, 16) AS BINARY) SET e.referrer_id = r.id",
+		"ALTER TABLE LOGENTRY DROP COLUMN referrer, ADD INDEX (referrer_id)",
+	}},
+	{12, "keep hourly and daily rollups of each log and path", []string{
+		// The rollup tables are created with the others; entries stored before this are only
+		// counted in them once they are rebuilt with implog rollup rebuild
+	}},
+	{13, "index path references so that unused paths can be purged", []string{
+		"ALTER TABLE LOGENTRY ADD INDEX (loguri_id)",
+		"ALTER TABLE " + rollupDay + " ADD INDEX (loguri_id)",
+		"ALTER TABLE " + rollupHour + " ADD INDEX (loguri_id)",
+	}},
+	{14, "partition LOGENTRY by month of request time", []string{
+		// Partitioned tables cannot have foreign keys, and their keys must include the partitioning column
+		"UPDATE LOGENTRY SET requesttime = '" + unknownTime + "' WHERE requesttime IS NULL",
+		"ALTER TABLE LOGENTRY MODIFY requesttime DATETIME NOT NULL, DROP PRIMARY KEY, ADD PRIMARY KEY (id, requesttime)",
+		// The monthly partitions are added by Init once the table is partitioned
+		"ALTER TABLE LOGENTRY" + partitionClause,
+	}},
+	{15, "key entries by log name, request time and line hash so they are stored in time order", []string{
+		// Existing ids were the base64url text of the start of each line rather than a hash of it, cut to 16 bytes
+		// (see legacyPrefix in httplog). The lines themselves were not stored, so the ids cannot be recomputed;
+		// they are kept, and lines requested before this migration are checked against them as well as their
+		// new ids, so that reading them again does not store them twice
+		"UPDATE LOGENTRY SET logname = '' WHERE logname IS NULL",
+		// logfile_id was an INT, into which the text of each LOGFILE id was converted as a number: its leading
+		// digits, or 0 for the many ids that start with a letter. Those cannot be told apart, so they are dropped
+		"UPDATE LOGENTRY SET logfile_id = NULL",
+		"ALTER TABLE LOGENTRY MODIFY logname VARCHAR(255) NOT NULL, MODIFY logfile_id BINARY(16), DROP PRIMARY KEY, ADD PRIMARY KEY (logname, requesttime, id), ADD INDEX (id)",
+	}},
+	{16, "store the text of ip addresses in canonical form", []string{
+		// Migration 2 copied addresses as they appeared in the log; canonicalizeAddresses rewrites them
+		// and merges addresses that turn out to be the same
+	}},
+	{17, "make the keys by which paths, referrers, clients and addresses are looked up unique", []string{
+		// Concurrent imports could insert the same key twice; mergeDuplicateKeys merges those rows first
+		"ALTER TABLE LOGURI DROP INDEX urihash, ADD UNIQUE INDEX (urihash)",
+		"ALTER TABLE LOGREFERRER DROP INDEX urihash, ADD UNIQUE INDEX (urihash)",
+		"ALTER TABLE CLIENT DROP INDEX uahash, ADD UNIQUE INDEX (uahash)",
+		"ALTER TABLE LOGIP DROP INDEX address, ADD UNIQUE INDEX (address)",
+	}},
+	{18, "mark the days whose rollups are still counted in memory", []string{
+		// ROLLUPPENDING is created with the others
+	}},
+}
+
+// canonicalizeAddresses rewrites the addresses in LOGIP that are not in canonical form, such as
+// IPv4-mapped IPv6 addresses, so that they match those looked up by new entries. An address whose
+// canonical form is already stored is merged into it, moving its entries across.
+func canonicalizeAddresses(ctx context.Context, s *LogStore) error {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, address FROM LOGIP WHERE address IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	changed := make(map[string]string)
+	for rows.Next() {
+		var id, address string
+		err = rows.Scan(&id, &address)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if canonical := ipaddr.Canonical(address); canonical != address {
+			changed[id] = canonical
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for id, address := range changed {
+		var existing string
+		err = s.db.QueryRowContext(ctx, "SELECT id FROM LOGIP WHERE address = ?", address).Scan(&existing)
+		switch {
+		case err == sql.ErrNoRows:
+			addr, _ := ipaddr.Parse(address)
+			_, err = s.db.ExecContext(ctx, "UPDATE LOGIP SET address = ?, ip = ? WHERE id = ?", address, ipaddr.Bytes(addr), id)
+		case err == nil:
+			_, err = s.db.ExecContext(ctx, "UPDATE LOGENTRY SET logip_id = ? WHERE logip_id = ?", existing, id)
+			if err == nil {
+				_, err = s.db.ExecContext(ctx, "DELETE FROM LOGIP WHERE id = ?", id)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	log.Printf("Canonicalized %v ip addresses\n", len(changed))
+	return nil
+}
+
+// findLegacyEntries records when migration 15 was applied, if entries stored before it remain. Those have
+// no request time and are identified by the id earlier versions gave their line, so lines requested before
+// then are checked against it. A day is allowed for the difference between the time zones of the log and
+// the database.
+func (s *LogStore) findLegacyEntries(ctx context.Context) error {
+	var applied mysql.NullTime
+	err := s.db.QueryRowContext(ctx, "SELECT applied FROM SCHEMAVERSION WHERE version = 15 AND EXISTS "+
+		"(SELECT 1 FROM LOGENTRY WHERE requesttime = '"+unknownTime+"')").Scan(&applied)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if applied.Valid {
+		s.legacyBefore = applied.Time.AddDate(0, 0, 1)
+	}
+	return nil
+}
+
+// isNewDatabase reports whether the log tables have yet to be created
+func (s *LogStore) isNewDatabase(ctx context.Context) (bool, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = 'LOGENTRY'").Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count == 0, nil
+}
+
+// schemaVersion reports the latest migration applied to the database
+func (s *LogStore) schemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := s.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM SCHEMAVERSION").Scan(&version)
+	return version, err
+}
+
+// migrate brings the schema up to date, applying any migrations the database has not seen.
+// A database created from the current schema is simply stamped with the latest version.
+func (s *LogStore) migrate(ctx context.Context, fresh bool) error {
+	_, err := s.db.ExecContext(ctx, createSchemaVersionTable)
+	if err != nil {
+		return err
+	}
+	current, err := s.schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if !fresh {
+			log.Printf("Applying schema migration %v: %v\n", m.version, m.description)
+			if prepare, ok := preparations[m.version]; ok {
+				err = prepare(ctx, s)
+				if err != nil {
+					return fmt.Errorf("migration %v: %w", m.version, err)
+				}
+			}
+			for _, stmt := range m.statements {
+				_, err = s.db.ExecContext(ctx, stmt)
+				if err != nil {
+					return fmt.Errorf("migration %v: %w", m.version, err)
+				}
+			}
+		}
+		_, err = s.db.ExecContext(ctx, "INSERT INTO SCHEMAVERSION (version, description) VALUES (?,?)", m.version, m.description)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package mysql
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/infodancer/implog/session"
+)
+
+const createSessionTable = createTable + "SESSION (" + idField + ", logname VARCHAR(255), visitor BINARY(20), starttime DATETIME, endtime DATETIME, entry_uri_id BINARY(16), exit_uri_id BINARY(16), hits INT, bytes BIGINT, bounce BOOLEAN, INDEX (logname, starttime), INDEX (logname, endtime))"
+const dropSessionTable = dropTable + " SESSION"
+const upsertSessionQuery = "INSERT INTO SESSION (id, logname, visitor, starttime, endtime, entry_uri_id, exit_uri_id, hits, bytes, bounce) VALUES (?,?,?,?,?,?,?,?,?,?) " +
+	"ON DUPLICATE KEY UPDATE starttime = VALUES(starttime), endtime = VALUES(endtime), entry_uri_id = VALUES(entry_uri_id), exit_uri_id = VALUES(exit_uri_id), hits = VALUES(hits), bytes = VALUES(bytes), bounce = VALUES(bounce)"
+
+// sessionBatchSize is the number of entries assigned to sessions in each transaction
+const sessionBatchSize = 1000
+
+// sessionHash returns the stored form of a session cookie, which is hashed so that a copy of the database
+// cannot be used to take over a session, or nil if there is no cookie. A privacy policy with a key has already
+// replaced the cookie with a keyed HMAC, so that it cannot be found by hashing guesses either.
+func sessionHash(cookie string) interface{} {
+	if cookie == "" {
+		return nil
+	}
+	hash := sha1.Sum([]byte(cookie))
+	return hash[:]
+}
+
+// visitor identifies the visitor that made a request within a log: by session cookie if there is one and
+// cookies are in use, otherwise by address and user agent
+func visitor(logname string, ipID []byte, clientID []byte, cookie []byte, useCookie bool) string {
+	key := []byte(logname + "\x00")
+	if useCookie && cookie != nil {
+		key = append(key, 'c')
+		key = append(key, cookie...)
+	} else {
+		key = append(key, 'v')
+		key = append(key, ipID...)
+		key = append(key, clientID...)
+	}
+	hash := sha1.Sum(key)
+	return string(hash[:])
+}
+
+// assignment records the session an entry belongs to
+type assignment struct {
+	logname   string
+	entryID   []byte
+	sessionID string
+}
+
+// Sessionize groups the entries of a log (or of every log, if logname is empty) that are not yet part of
+// a session into sessions, ending a session after timeout without a request, and reports how many entries
+// were grouped. Sessions still open from an earlier run are extended, so it can be run after each import.
+func (s *LogStore) Sessionize(ctx context.Context, logname string, timeout time.Duration, useCookie bool) (int, error) {
+	if timeout <= 0 {
+		timeout = session.DefaultTimeout
+	}
+	tracker := session.NewTracker(timeout, newID)
+
+	filter := ""
+	args := []interface{}{}
+	if logname != "" {
+		filter = " AND logname = ?"
+		args = append(args, logname)
+	}
+	var first mysql.NullTime
+	err := s.db.QueryRowContext(ctx, "SELECT MIN(requesttime) FROM LOGENTRY WHERE session_id IS NULL AND "+knownTime+filter, args...).Scan(&first)
+	if err != nil {
+		return 0, err
+	}
+	if !first.Valid {
+		return 0, nil
+	}
+	err = s.resumeSessions(ctx, tracker, first.Time.Add(-timeout), filter, args)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT id, logname, requesttime, logip_id, client_id, loguri_id, size, sessionhash FROM LOGENTRY "+
+		"WHERE session_id IS NULL AND "+knownTime+filter+" ORDER BY requesttime", args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	count := 0
+	dirty := make(map[string]*session.Session)
+	assigned := make([]assignment, 0, sessionBatchSize)
+	for rows.Next() {
+		var entryID, ipID, clientID, uriID, cookie []byte
+		var name string
+		var requestTime mysql.NullTime
+		var size sql.NullInt64
+		err = rows.Scan(&entryID, &name, &requestTime, &ipID, &clientID, &uriID, &size, &cookie)
+		if err != nil {
+			return count, err
+		}
+		sess := tracker.Add(session.Hit{
+			Visitor: visitor(name, ipID, clientID, cookie, useCookie),
+			LogName: name,
+			Time:    requestTime.Time,
+			URI:     string(uriID),
+			Bytes:   size.Int64,
+		})
+		dirty[sess.ID] = sess
+		assigned = append(assigned, assignment{logname: name, entryID: entryID, sessionID: sess.ID})
+		if len(assigned) >= sessionBatchSize {
+			err = s.writeSessions(ctx, dirty, assigned)
+			if err != nil {
+				return count, err
+			}
+			count += len(assigned)
+			dirty = make(map[string]*session.Session)
+			assigned = assigned[:0]
+			tracker.Expire(requestTime.Time)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return count, err
+	}
+	err = s.writeSessions(ctx, dirty, assigned)
+	if err != nil {
+		return count, err
+	}
+	return count + len(assigned), nil
+}
+
+// resumeSessions loads the sessions that ended after the given time, so that new entries can extend them
+func (s *LogStore) resumeSessions(ctx context.Context, tracker *session.Tracker, after time.Time, filter string, args []interface{}) error {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, logname, visitor, starttime, endtime, entry_uri_id, exit_uri_id, hits, bytes FROM SESSION WHERE endtime >= ?"+filter,
+		append([]interface{}{after}, args...)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, visitor, entryURI, exitURI []byte
+		var start, end mysql.NullTime
+		sess := session.Session{}
+		err = rows.Scan(&id, &sess.LogName, &visitor, &start, &end, &entryURI, &exitURI, &sess.Hits, &sess.Bytes)
+		if err != nil {
+			return err
+		}
+		sess.ID = string(id)
+		sess.Visitor = string(visitor)
+		sess.Start = start.Time
+		sess.End = end.Time
+		sess.EntryURI = string(entryURI)
+		sess.ExitURI = string(exitURI)
+		tracker.Resume(&sess)
+	}
+	return rows.Err()
+}
+
+// writeSessions stores a batch of new and extended sessions along with the entries assigned to them
+func (s *LogStore) writeSessions(ctx context.Context, sessions map[string]*session.Session, assigned []assignment) error {
+	if len(assigned) == 0 {
+		return nil
+	}
+	start := time.Now()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	upsert, err := tx.PrepareContext(ctx, upsertSessionQuery)
+	if err != nil {
+		return err
+	}
+	defer upsert.Close()
+	for _, sess := range sessions {
+		_, err = upsert.ExecContext(ctx, sess.ID, sess.LogName, []byte(sess.Visitor), sess.Start, sess.End,
+			nullString(sess.EntryURI), nullString(sess.ExitURI), sess.Hits, sess.Bytes, sess.Bounce())
+		if err != nil {
+			return err
+		}
+	}
+	update, err := tx.PrepareContext(ctx, "UPDATE LOGENTRY SET session_id = ? WHERE logname = ? AND id = ?")
+	if err != nil {
+		return err
+	}
+	defer update.Close()
+	for _, a := range assigned {
+		_, err = update.ExecContext(ctx, a.sessionID, a.logname, a.entryID)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err == nil && s.observer != nil {
+		s.observer.ObserveBatch("session", len(assigned), time.Since(start))
+	}
+	return err
+}
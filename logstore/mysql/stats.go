@@ -0,0 +1,37 @@
+package mysql
+
+import (
+	"sync"
+
+	"github.com/infodancer/implog/logstore"
+)
+
+// Stats reports the number of entries in each cache of ids and the statistics of the connection pool
+func (s *LogStore) Stats() logstore.Stats {
+	result := logstore.Stats{Caches: make(map[string]int)}
+	caches := []struct {
+		name  string
+		mutex *sync.Mutex
+		cache *map[string]string
+	}{
+		{"logfilecache", s.lfcMutex, &s.logfilecache},
+		{"ipcache", s.ipcMutex, &s.ipcache},
+		{"uricache", s.uriMutex, &s.uricache},
+		{"refercache", s.referMutex, &s.refercache},
+		{"clientcache", s.clientMutex, &s.clientcache},
+	}
+	for _, c := range caches {
+		c.mutex.Lock()
+		result.Caches[c.name] = len(*c.cache)
+		c.mutex.Unlock()
+	}
+	if s.db != nil {
+		result.DB = s.db.Stats()
+	}
+	return result
+}
+
+// SetObserver sets the observer told of the batches of rollups and sessions written, before any are written
+func (s *LogStore) SetObserver(o logstore.Observer) {
+	s.observer = o
+}
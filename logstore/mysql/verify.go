@@ -0,0 +1,116 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// danglingQueries count the entries and sessions referring to rows that do not exist, by the kind of row
+var danglingQueries = []struct {
+	rows  string
+	name  string
+	query string
+}{
+	{"entries", "paths", "SELECT COUNT(*) FROM LOGENTRY e LEFT JOIN LOGURI u ON u.id = e.loguri_id WHERE e.loguri_id IS NOT NULL AND u.id IS NULL"},
+	{"entries", "addresses", "SELECT COUNT(*) FROM LOGENTRY e LEFT JOIN LOGIP i ON i.id = e.logip_id WHERE e.logip_id IS NOT NULL AND i.id IS NULL"},
+	{"entries", "referrers", "SELECT COUNT(*) FROM LOGENTRY e LEFT JOIN LOGREFERRER r ON r.id = e.referrer_id WHERE e.referrer_id IS NOT NULL AND r.id IS NULL"},
+	{"entries", "user agents", "SELECT COUNT(*) FROM LOGENTRY e LEFT JOIN CLIENT c ON c.id = e.client_id WHERE e.client_id IS NOT NULL AND c.id IS NULL"},
+	{"entries", "log files", "SELECT COUNT(*) FROM LOGENTRY e LEFT JOIN LOGFILE f ON f.id = e.logfile_id WHERE e.logfile_id IS NOT NULL AND f.id IS NULL"},
+	{"entries", "sessions", "SELECT COUNT(*) FROM LOGENTRY e LEFT JOIN SESSION s ON s.id = e.session_id WHERE e.session_id IS NOT NULL AND s.id IS NULL"},
+	{"sessions", "paths", "SELECT COUNT(*) FROM SESSION s LEFT JOIN LOGURI u ON u.id = s.entry_uri_id LEFT JOIN LOGURI x ON x.id = s.exit_uri_id " +
+		"WHERE (s.entry_uri_id IS NOT NULL AND u.id IS NULL) OR (s.exit_uri_id IS NOT NULL AND x.id IS NULL)"},
+}
+
+// SchemaVersion reports the latest migration applied to the database, which is 0 if it has never been initialized
+func (s *LogStore) SchemaVersion(ctx context.Context) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = 'SCHEMAVERSION'").Scan(&count)
+	if err != nil || count == 0 {
+		return 0, err
+	}
+	return s.schemaVersion(ctx)
+}
+
+// Verify checks the consistency of the store, listing the problems found: a schema that is not up to date,
+// entries and sessions referring to missing rows, parameters of missing entries, days whose rollups are still pending,
+// and daily rollups that do not match the entries of their day. Every check scans whole tables, so this is slow on a large store.
+func (s *LogStore) Verify(ctx context.Context) ([]string, error) {
+	problems := make([]string, 0)
+	version, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return problems, err
+	}
+	latest := migrations[len(migrations)-1].version
+	if version != latest {
+		problems = append(problems, fmt.Sprintf("schema version is %v, not %v", version, latest))
+	}
+
+	for _, q := range danglingQueries {
+		var count int
+		err = s.db.QueryRowContext(ctx, q.query).Scan(&count)
+		if err != nil {
+			return problems, fmt.Errorf("checking %v: %w", q.name, err)
+		}
+		if count > 0 {
+			problems = append(problems, fmt.Sprintf("%v %v refer to missing %v", count, q.rows, q.name))
+		}
+	}
+
+	var params int
+	err = s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM LOGPARAM p LEFT JOIN LOGENTRY e ON e.id = p.logentry_id WHERE e.id IS NULL").Scan(&params)
+	if err != nil {
+		return problems, fmt.Errorf("checking parameters: %w", err)
+	}
+	if params > 0 {
+		problems = append(problems, fmt.Sprintf("%v parameters belong to missing entries", params))
+	}
+
+	var pending int
+	err = s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM (SELECT DISTINCT logname, day FROM "+rollupPending+") p").Scan(&pending)
+	if err != nil {
+		return problems, fmt.Errorf("checking pending rollups: %w", err)
+	}
+	if pending > 0 {
+		problems = append(problems, fmt.Sprintf("%v days have rollups that an import has not finished writing; unless one is running, "+
+			"rebuild them with implog rollup rebuild -pending", pending))
+	}
+
+	mismatches, err := s.verifyRollups(ctx)
+	if err != nil {
+		return problems, fmt.Errorf("checking rollups: %w", err)
+	}
+	return append(problems, mismatches...), nil
+}
+
+// verifyRollups compares the hits of each daily rollup with the entries of its day. Purging keeps the rollups,
+// so a log's earliest day may have been partly purged and is allowed to count more hits than it has entries.
+func (s *LogStore) verifyRollups(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT e.logname, DATE(e.requesttime), COUNT(*), COALESCE(MAX(d.hits), 0) FROM LOGENTRY e "+
+		"LEFT JOIN "+rollupDay+" d ON d.logname = e.logname AND d.period = DATE(e.requesttime) AND d.loguri_id = ? "+
+		"WHERE e."+knownTime+" GROUP BY e.logname, DATE(e.requesttime) ORDER BY e.logname, DATE(e.requesttime)", []byte(wholeLog))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	problems := make([]string, 0)
+	previous := ""
+	for rows.Next() {
+		var logname string
+		var day mysql.NullTime
+		var entries, hits int64
+		err = rows.Scan(&logname, &day, &entries, &hits)
+		if err != nil {
+			return nil, err
+		}
+		first := logname != previous
+		previous = logname
+		if hits == entries || (first && hits > entries) {
+			continue
+		}
+		problems = append(problems, fmt.Sprintf("%v on %v: the rollup counts %v hits but there are %v entries",
+			logname, day.Time.Format("2006-01-02"), hits, entries))
+	}
+	return problems, rows.Err()
+}
@@ -0,0 +1,77 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+)
+
+// writeTx is a transaction writing an entry along with the rows it refers to. The ids of rows inserted in it
+// are only cached, and new addresses only queued for lookup, once it commits, since the rows vanish if it is
+// rolled back. A nil writeTx runs statements outside any transaction and caches at once.
+type writeTx struct {
+	tx        *sql.Tx
+	committed []func()
+}
+
+// beginWrite starts a transaction for writing an entry. Each statement sees the rows committed before it ran,
+// rather than those committed before the transaction began, so that a row inserted by a concurrent import
+// can be found again after the insert here finds it already exists.
+func (s *LogStore) beginWrite(ctx context.Context) (*writeTx, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, err
+	}
+	return &writeTx{tx: tx}, nil
+}
+
+// stmt returns a prepared statement for use in the transaction
+func (w *writeTx) stmt(ctx context.Context, stmt *sql.Stmt) *sql.Stmt {
+	if w == nil {
+		return stmt
+	}
+	return w.tx.StmtContext(ctx, stmt)
+}
+
+// afterCommit runs fn once the transaction has committed
+func (w *writeTx) afterCommit(fn func()) {
+	if w == nil {
+		fn()
+		return
+	}
+	w.committed = append(w.committed, fn)
+}
+
+func (w *writeTx) commit() error {
+	err := w.tx.Commit()
+	if err != nil {
+		return err
+	}
+	for _, fn := range w.committed {
+		fn()
+	}
+	return nil
+}
+
+func (w *writeTx) rollback() {
+	w.tx.Rollback()
+}
+
+// insertUnique inserts a row, whose id is the first of its arguments, with an insert that does nothing if a row
+// with the same unique key already exists, as when a concurrent import has just inserted it. The id of that row
+// is then looked up by its key and returned instead. It reports whether the row was inserted.
+func (w *writeTx) insertUnique(ctx context.Context, insert *sql.Stmt, selectID *sql.Stmt, key interface{}, args ...interface{}) (string, bool, error) {
+	res, err := w.stmt(ctx, insert).ExecContext(ctx, args...)
+	if err != nil {
+		return "", false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return "", false, err
+	}
+	if n > 0 {
+		return args[0].(string), true, nil
+	}
+	var id string
+	err = w.stmt(ctx, selectID).QueryRowContext(ctx, key).Scan(&id)
+	return id, false, err
+}
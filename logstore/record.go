@@ -0,0 +1,46 @@
+package logstore
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// CombinedTime is the layout of request times in the combined log format
+const CombinedTime = "02/Jan/2006:15:04:05 -0700"
+
+// Record is a stored entry read back with its paths, address, referrer and user agent filled in.
+// Query strings are kept apart from paths, so Path has none.
+type Record struct {
+	LogName      string    `json:"logname"`
+	Time         time.Time `json:"time"`
+	Address      string    `json:"address"`
+	Ident        string    `json:"ident,omitempty"`
+	Auth         string    `json:"auth,omitempty"`
+	Method       string    `json:"method"`
+	Path         string    `json:"path"`
+	Protocol     string    `json:"protocol"`
+	Status       int64     `json:"status"`
+	Size         int64     `json:"size"`
+	Referrer     string    `json:"referrer,omitempty"`
+	UserAgent    string    `json:"user_agent,omitempty"`
+	TrafficClass string    `json:"traffic_class,omitempty"`
+}
+
+// Combined formats the record as a line of the combined log format
+func (r *Record) Combined() string {
+	size := "-"
+	if r.Size > 0 {
+		size = strconv.FormatInt(r.Size, 10)
+	}
+	return fmt.Sprintf("%v %v %v [%v] \"%v %v %v\" %v %v \"%v\" \"%v\"", orDash(r.Address), orDash(r.Ident), orDash(r.Auth),
+		r.Time.Format(CombinedTime), r.Method, r.Path, r.Protocol, r.Status, size, orDash(r.Referrer), orDash(r.UserAgent))
+}
+
+// orDash returns a field as it is written in a log line, where a missing value is a dash
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,20 @@
+package logstore
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Stats describes the state of a LogStore for monitoring
+type Stats struct {
+	// Caches holds the number of entries in each cache of ids, such as ipcache
+	Caches map[string]int
+	// DB holds the statistics of the database connection pool
+	DB sql.DBStats
+}
+
+// Observer is told of the batches of rows written by a LogStore, for monitoring
+type Observer interface {
+	// ObserveBatch records that rows of a kind, such as rollups, were written together, taking elapsed
+	ObserveBatch(kind string, rows int, elapsed time.Duration)
+}
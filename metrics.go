@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/infodancer/implog/logstore"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// importMetrics counts the lines of each log and times the writes to the store, for a scraper to collect
+type importMetrics struct {
+	registry     *prometheus.Registry
+	lines        *prometheus.CounterVec
+	parsed       *prometheus.CounterVec
+	rejected     *prometheus.CounterVec
+	inserted     *prometheus.CounterVec
+	duplicates   *prometheus.CounterVec
+	errors       *prometheus.CounterVec
+	writeSeconds *prometheus.HistogramVec
+	batchRows    *prometheus.HistogramVec
+	// entryWrites is the series of writeSeconds timing single entries, which is looked up once
+	entryWrites prometheus.Observer
+}
+
+// logCounters are the counters of one log, looked up once for each file rather than for each line
+type logCounters struct {
+	lines      prometheus.Counter
+	parsed     prometheus.Counter
+	rejected   prometheus.Counter
+	inserted   prometheus.Counter
+	duplicates prometheus.Counter
+	errors     prometheus.Counter
+}
+
+func newImportMetrics(registry *prometheus.Registry) *importMetrics {
+	result := importMetrics{}
+	result.registry = registry
+	counter := func(name string, help string) *prometheus.CounterVec {
+		c := prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, []string{"logname"})
+		registry.MustRegister(c)
+		return c
+	}
+	result.lines = counter("implog_lines_read_total", "Lines read from log files.")
+	result.parsed = counter("implog_lines_parsed_total", "Lines parsed into entries.")
+	result.rejected = counter("implog_lines_rejected_total", "Lines that could not be parsed.")
+	result.inserted = counter("implog_entries_inserted_total", "Entries written to the store (or found to be new, in a dry run).")
+	result.duplicates = counter("implog_entries_duplicate_total", "Entries already in the store.")
+	result.errors = counter("implog_entries_failed_total", "Entries that could not be written to the store.")
+	result.writeSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "implog_db_write_seconds",
+		Help:    "Time taken to write an entry, or a batch of rollups or sessions, to the store.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 2, 16),
+	}, []string{"operation"})
+	result.batchRows = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "implog_db_batch_rows",
+		Help:    "Rows written to the store in each batch of rollups or sessions.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+	}, []string{"batch"})
+	registry.MustRegister(result.writeSeconds, result.batchRows)
+	result.entryWrites = result.writeSeconds.WithLabelValues("entry")
+	return &result
+}
+
+// forLog returns the counters of a log
+func (m *importMetrics) forLog(logname string) *logCounters {
+	return &logCounters{
+		lines:      m.lines.WithLabelValues(logname),
+		parsed:     m.parsed.WithLabelValues(logname),
+		rejected:   m.rejected.WithLabelValues(logname),
+		inserted:   m.inserted.WithLabelValues(logname),
+		duplicates: m.duplicates.WithLabelValues(logname),
+		errors:     m.errors.WithLabelValues(logname),
+	}
+}
+
+// ObserveBatch records a batch of rows written by the store
+func (m *importMetrics) ObserveBatch(kind string, rows int, elapsed time.Duration) {
+	m.writeSeconds.WithLabelValues(kind).Observe(elapsed.Seconds())
+	m.batchRows.WithLabelValues(kind).Observe(float64(rows))
+}
+
+// labelledGauge is a gauge with one label, whose values are read when it is collected
+type labelledGauge struct {
+	desc   *prometheus.Desc
+	values func() map[string]float64
+}
+
+func newLabelledGauge(name string, help string, label string, values func() map[string]float64) *labelledGauge {
+	return &labelledGauge{desc: prometheus.NewDesc(name, help, []string{label}, nil), values: values}
+}
+
+// Describe implements prometheus.Collector
+func (g *labelledGauge) Describe(ch chan<- *prometheus.Desc) {
+	ch <- g.desc
+}
+
+// Collect implements prometheus.Collector
+func (g *labelledGauge) Collect(ch chan<- prometheus.Metric) {
+	for label, value := range g.values() {
+		ch <- prometheus.MustNewConstMetric(g.desc, prometheus.GaugeValue, value, label)
+	}
+}
+
+// registerUnread adds a gauge of the bytes of each log not yet read from the files being imported.
+// An import reads the files as they were when it found them and does not follow them as they grow,
+// so this is how far the import has to go, not how far it is behind the logs being written.
+func (im *importer) registerUnread() {
+	im.metrics.registry.MustRegister(newLabelledGauge("implog_import_unread_bytes",
+		"Bytes of the log files found by this import not yet read.", "logname", func() map[string]float64 {
+			unread := make(map[string]float64)
+			im.statsMutex.Lock()
+			defer im.statsMutex.Unlock()
+			for _, st := range im.stats {
+				var left int64
+				if !st.isDone() {
+					left = st.size - atomic.LoadInt64(&st.read)
+				}
+				unread[st.logname] += float64(left)
+			}
+			return unread
+		}))
+}
+
+// registerStoreMetrics adds gauges of the sizes of the store's caches and of its database connections
+func registerStoreMetrics(registry *prometheus.Registry, store logstore.LogStore) {
+	registry.MustRegister(newLabelledGauge("implog_cache_entries", "Entries in each of the store's caches of ids.", "cache",
+		func() map[string]float64 {
+			caches := store.Stats().Caches
+			result := make(map[string]float64, len(caches))
+			for name, size := range caches {
+				result[name] = float64(size)
+			}
+			return result
+		}))
+	gauge := func(name string, help string, value func(s logstore.Stats) float64) {
+		registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: name, Help: help},
+			func() float64 { return value(store.Stats()) }))
+	}
+	counter := func(name string, help string, value func(s logstore.Stats) float64) {
+		registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help},
+			func() float64 { return value(store.Stats()) }))
+	}
+	gauge("implog_db_max_open_connections", "Maximum number of open connections to the database.",
+		func(s logstore.Stats) float64 { return float64(s.DB.MaxOpenConnections) })
+	gauge("implog_db_open_connections", "Connections to the database, in use or idle.",
+		func(s logstore.Stats) float64 { return float64(s.DB.OpenConnections) })
+	gauge("implog_db_in_use_connections", "Connections to the database in use.",
+		func(s logstore.Stats) float64 { return float64(s.DB.InUse) })
+	gauge("implog_db_idle_connections", "Idle connections to the database.",
+		func(s logstore.Stats) float64 { return float64(s.DB.Idle) })
+	counter("implog_db_wait_count_total", "Connections waited for.",
+		func(s logstore.Stats) float64 { return float64(s.DB.WaitCount) })
+	counter("implog_db_wait_duration_seconds_total", "Time spent waiting for connections.",
+		func(s logstore.Stats) float64 { return s.DB.WaitDuration.Seconds() })
+	counter("implog_db_max_idle_closed_total", "Connections closed because too many were idle.",
+		func(s logstore.Stats) float64 { return float64(s.DB.MaxIdleClosed) })
+	counter("implog_db_max_idle_time_closed_total", "Connections closed because they were idle too long.",
+		func(s logstore.Stats) float64 { return float64(s.DB.MaxIdleTimeClosed) })
+	counter("implog_db_max_lifetime_closed_total", "Connections closed because they were open too long.",
+		func(s logstore.Stats) float64 { return float64(s.DB.MaxLifetimeClosed) })
+}
+
+// listenMetrics serves the metrics of a registry at /metrics on an address, returning a function that stops serving them.
+// The address is listened on before returning, so that a mistake in it is reported at once.
+func listenMetrics(addr string, registry *prometheus.Registry) (func(), error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      time.Minute,
+	}
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("metrics: %v\n", err)
+		}
+	}()
+	log.Printf("Serving metrics on %v/metrics\n", listener.Addr())
+	return func() {
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}, nil
+}
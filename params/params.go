@@ -0,0 +1,113 @@
+package params
+
+import (
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxNameLength is the longest parameter name that is kept
+const MaxNameLength = 255
+
+// Param is a single decoded query string parameter
+type Param struct {
+	Name  string
+	Value string
+}
+
+// Parse splits a query string into decoded parameters, in the order they appear.
+// Values that cannot be decoded are kept as they are, parameters without a name are dropped
+// and names longer than MaxNameLength are truncated.
+func Parse(query string) []Param {
+	result := make([]Param, 0)
+	for query != "" {
+		var pair string
+		pair, query, _ = strings.Cut(query, "&")
+		if pair == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(pair, "=")
+		name = unescape(name)
+		if name == "" {
+			continue
+		}
+		result = append(result, Param{Name: truncate(name, MaxNameLength), Value: unescape(value)})
+	}
+	return result
+}
+
+func unescape(s string) string {
+	u, err := url.QueryUnescape(s)
+	if err != nil {
+		return s
+	}
+	return u
+}
+
+// truncate shortens a string to at most n bytes without splitting a character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// Filter decides which parameters are kept, by name.
+// Names are matched case-insensitively against glob patterns such as utm_*.
+type Filter struct {
+	allow []string
+	deny  []string
+}
+
+// NewFilter creates a filter that keeps parameters matching any allow pattern (or every parameter, if there are none)
+// unless they also match a deny pattern
+func NewFilter(allow []string, deny []string) *Filter {
+	result := Filter{}
+	result.allow = patterns(allow)
+	result.deny = patterns(deny)
+	return &result
+}
+
+func patterns(list []string) []string {
+	result := make([]string, 0, len(list))
+	for _, p := range list {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p != "" {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// Allowed reports whether a parameter with the given name is kept
+func (f *Filter) Allowed(name string) bool {
+	name = strings.ToLower(name)
+	if matchAny(name, f.deny) {
+		return false
+	}
+	return len(f.allow) == 0 || matchAny(name, f.allow)
+}
+
+// Apply returns the parameters that are kept
+func (f *Filter) Apply(ps []Param) []Param {
+	result := make([]Param, 0, len(ps))
+	for _, p := range ps {
+		if f.Allowed(p.Name) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func matchAny(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,270 @@
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/infodancer/implog/httplog"
+	"github.com/infodancer/implog/ipaddr"
+)
+
+// Policy options
+const (
+	// TruncateIP keeps only the network part of client addresses: a /24 for IPv4 and a /48 for IPv6
+	TruncateIP = "truncate-ip"
+	// HashIP replaces client addresses with a keyed HMAC, so visits can still be told apart but not traced
+	HashIP = "hash-ip"
+	// DropAuth removes the authenticated user name
+	DropAuth = "drop-auth"
+	// Scrub redacts query string parameters matching the scrub patterns, in both requests and referrers
+	Scrub = "scrub"
+)
+
+// None describes a policy that changes nothing
+const None = "none"
+
+// Redacted replaces the values of scrubbed parameters
+const Redacted = "REDACTED"
+
+// MinKeyLength is the shortest key accepted for pseudonymizing addresses
+const MinKeyLength = 16
+
+// DefaultScrubPatterns are used when scrubbing is enabled without any patterns of its own.
+// Patterns are matched against each decoded name=value pair of a query string.
+var DefaultScrubPatterns = []string{
+	`(?i)^(token|access_token|id_token|auth|api_?key|password|passwd|pwd|secret|email|e-mail|mail|session|sessionid|sid)=`,
+	`(?i)[a-z0-9._%+-]+@[a-z0-9-]+(\.[a-z0-9-]+)*\.[a-z]{2,}`,
+}
+
+// Policy removes or disguises personal data in log entries before they are stored
+type Policy struct {
+	truncateIP bool
+	hashIP     bool
+	dropAuth   bool
+	key        []byte
+	scrub      []*regexp.Regexp
+}
+
+// New creates a policy from a list of options. Altering addresses or scrubbing requires a key of at least
+// MinKeyLength bytes, with which entry ids are disguised, since the ids of the lines they change cannot otherwise
+// be both distinct and safe; dropping user names uses a key, if one is given, in the same way.
+// Giving scrub patterns enables scrubbing; enabling it without any uses DefaultScrubPatterns.
+func New(options []string, key []byte, scrubPatterns []string) (*Policy, error) {
+	result := Policy{}
+	scrub := false
+	for _, o := range options {
+		switch strings.ToLower(strings.TrimSpace(o)) {
+		case "", None:
+		case TruncateIP:
+			result.truncateIP = true
+		case HashIP:
+			result.hashIP = true
+		case DropAuth:
+			result.dropAuth = true
+		case Scrub:
+			scrub = true
+		default:
+			return nil, fmt.Errorf("unknown privacy option: %v", o)
+		}
+	}
+	if len(key) > 0 {
+		if len(key) < MinKeyLength {
+			return nil, fmt.Errorf("the privacy key must be at least %v bytes", MinKeyLength)
+		}
+		result.key = key
+	}
+	patterns := make([]string, 0)
+	for _, p := range scrubPatterns {
+		if p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	if scrub && len(patterns) == 0 {
+		patterns = DefaultScrubPatterns
+	}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scrub pattern %q: %w", p, err)
+		}
+		result.scrub = append(result.scrub, re)
+	}
+	if (result.ChangesIP() || len(result.scrub) > 0) && result.key == nil {
+		return nil, fmt.Errorf("%v, %v and %v need a key of at least %v bytes", TruncateIP, HashIP, Scrub, MinKeyLength)
+	}
+	return &result, nil
+}
+
+// ReadKey extracts a pseudonymization key from the contents of a key file, ignoring surrounding whitespace
+func ReadKey(data []byte) ([]byte, error) {
+	key := []byte(strings.TrimSpace(string(data)))
+	if len(key) == 0 {
+		return nil, errors.New("the privacy key is empty")
+	}
+	return key, nil
+}
+
+// String lists the options of the policy, in the form accepted by New, for recording alongside the data
+func (p *Policy) String() string {
+	options := make([]string, 0)
+	if p.truncateIP {
+		options = append(options, TruncateIP)
+	}
+	if p.hashIP {
+		options = append(options, HashIP)
+	}
+	if p.dropAuth {
+		options = append(options, DropAuth)
+	}
+	if len(p.scrub) > 0 {
+		options = append(options, Scrub)
+	}
+	if len(options) == 0 {
+		return None
+	}
+	return strings.Join(options, ",")
+}
+
+// ChangesIP reports whether the policy alters client addresses, in which case their names should not be looked up
+func (p *Policy) ChangesIP() bool {
+	return p.truncateIP || p.hashIP
+}
+
+// active reports whether the policy changes anything
+func (p *Policy) active() bool {
+	return p.ChangesIP() || p.dropAuth || len(p.scrub) > 0
+}
+
+// Apply removes or disguises the personal data in an entry.
+// The id of the entry is a hash of its line, which holds the original data, so it is replaced as well.
+func (p *Policy) Apply(e *httplog.EntryData) {
+	if !p.active() {
+		return
+	}
+	if p.key != nil && e.SessionCookie != "" {
+		e.SessionCookie = hex.EncodeToString(p.mac([]byte(e.SessionCookie)))
+	}
+	if p.ChangesIP() {
+		e.IPAddress = p.AnonymizeIP(e.IPAddress)
+	}
+	if p.dropAuth && e.ClientAuth != "" {
+		e.ClientAuth = "-"
+	}
+	if len(p.scrub) > 0 {
+		if e.RequestParams != "" {
+			query := p.scrubQuery(e.RequestURI[len(e.RequestPath)+1:])
+			e.RequestURI = e.RequestPath + "?" + query
+			e.RequestParams, _, _ = strings.Cut(query, "?")
+		}
+		e.Referrer = p.scrubURL(e.Referrer)
+	}
+	e.UUID = p.entryID(e)
+}
+
+// entryID returns the id of an entry once the policy has been applied to it: a keyed HMAC of the hash of
+// its line, so that distinct lines keep distinct ids but the line cannot be recovered by hashing guesses at
+// the data that was removed. Without a key, which only a policy dropping user names allows, the hash is kept.
+func (p *Policy) entryID(e *httplog.EntryData) []byte {
+	if p.key == nil {
+		return e.UUID
+	}
+	return p.mac(e.UUID)
+}
+
+// mac returns the keyed HMAC-SHA256 of data
+func (p *Policy) mac(data []byte) []byte {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// AnonymizeIP truncates and/or hashes an address according to the policy.
+// Anything that is not a valid address is replaced with "-" when truncating, since it may be a host name.
+func (p *Policy) AnonymizeIP(ip string) string {
+	addr, err := ipaddr.Parse(ip)
+	if p.truncateIP {
+		if err != nil {
+			ip = "-"
+		} else {
+			ip = truncate(addr).String()
+		}
+	} else if err == nil {
+		ip = addr.String()
+	}
+	if p.hashIP {
+		ip = hex.EncodeToString(p.mac([]byte(ip))[:16])
+	}
+	return ip
+}
+
+// truncate keeps the network part of an address
+func truncate(addr netip.Addr) netip.Addr {
+	bits := 48
+	if addr.Is4() {
+		bits = 24
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return addr
+	}
+	return prefix.Addr()
+}
+
+// scrubURL redacts the query string of a URL, leaving any fragment alone
+func (p *Policy) scrubURL(raw string) string {
+	q := strings.IndexByte(raw, '?')
+	if q < 0 {
+		return raw
+	}
+	query, fragment, hasFragment := strings.Cut(raw[q+1:], "#")
+	result := raw[:q+1] + p.scrubQuery(query)
+	if hasFragment {
+		result += "#" + fragment
+	}
+	return result
+}
+
+// scrubQuery redacts the value of each parameter whose decoded name=value pair matches a scrub pattern
+func (p *Policy) scrubQuery(query string) string {
+	pairs := strings.Split(query, "&")
+	changed := false
+	for i, pair := range pairs {
+		if pair == "" {
+			continue
+		}
+		decoded := unescape(pair)
+		for _, re := range p.scrub {
+			if re.MatchString(decoded) || re.MatchString(pair) {
+				name, _, _ := strings.Cut(pair, "=")
+				if re.MatchString(unescape(name)) {
+					// The name itself is personal data
+					pairs[i] = Redacted
+				} else {
+					pairs[i] = name + "=" + Redacted
+				}
+				changed = true
+				break
+			}
+		}
+	}
+	if !changed {
+		return query
+	}
+	return strings.Join(pairs, "&")
+}
+
+// unescape decodes part of a query string, returning it unchanged if it cannot be decoded
+func unescape(s string) string {
+	u, err := url.QueryUnescape(s)
+	if err != nil {
+		return s
+	}
+	return u
+}
@@ -0,0 +1,63 @@
+package privacy
+
+import (
+	"testing"
+
+	"github.com/infodancer/implog/httplog"
+)
+
+var testKey = []byte("0123456789abcdef")
+
+func TestApplyKeepsDistinctLinesDistinct(t *testing.T) {
+	policy, err := New([]string{TruncateIP, Scrub}, testKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The same request in the same second from two addresses in one /24
+	lines := []string{
+		`192.0.2.10 - - [10/Oct/2000:13:55:36 -0700] "GET /index.html?token=a HTTP/1.0" 200 2326`,
+		`192.0.2.20 - - [10/Oct/2000:13:55:36 -0700] "GET /index.html?token=b HTTP/1.0" 200 2326`,
+	}
+	entries := make([]*httplog.EntryData, len(lines))
+	for i, line := range lines {
+		entries[i], err = httplog.ParseLogLine(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		original := string(entries[i].UUID)
+		policy.Apply(entries[i])
+		if string(entries[i].UUID) == original {
+			t.Errorf("line %v kept the hash of its line as its id", i)
+		}
+	}
+	if entries[0].IPAddress != entries[1].IPAddress || entries[0].RequestURI != entries[1].RequestURI {
+		t.Fatalf("the policy left %v %v and %v %v apart", entries[0].IPAddress, entries[0].RequestURI, entries[1].IPAddress, entries[1].RequestURI)
+	}
+	if string(entries[0].UUID) == string(entries[1].UUID) {
+		t.Error("distinct lines were given the same id")
+	}
+}
+
+func TestNewRequiresKey(t *testing.T) {
+	tests := []struct {
+		options []string
+		key     []byte
+		valid   bool
+	}{
+		{[]string{TruncateIP}, nil, false},
+		{[]string{HashIP}, nil, false},
+		{[]string{Scrub}, nil, false},
+		{[]string{DropAuth}, nil, true},
+		{[]string{TruncateIP}, testKey, true},
+		{[]string{TruncateIP}, []byte("short"), false},
+	}
+	for _, tt := range tests {
+		_, err := New(tt.options, tt.key, nil)
+		if tt.valid && err != nil {
+			t.Errorf("%v with a key of %v bytes: %v", tt.options, len(tt.key), err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%v with a key of %v bytes was accepted", tt.options, len(tt.key))
+		}
+	}
+}
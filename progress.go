@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is how often the progress line is redrawn
+const progressInterval = time.Second
+
+// progress shows a line on the terminal with the part of each file read so far, the lines read per second
+// and the time left, redrawing it every progressInterval. Log messages are written above it.
+type progress struct {
+	// total is the size of every file to be read, updated atomically
+	total   int64
+	out     io.Writer
+	mutex   *sync.Mutex
+	im      *importer
+	start   time.Time
+	line    string
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// isTerminal reports whether a file is a terminal rather than a pipe or a regular file
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// startProgress starts showing the progress of an importer on standard error, if it is a terminal,
+// returning nil otherwise. Log messages go through the progress line until it is stopped.
+func startProgress(im *importer) *progress {
+	if !isTerminal(os.Stderr) {
+		return nil
+	}
+	result := progress{}
+	result.out = os.Stderr
+	result.mutex = &sync.Mutex{}
+	result.im = im
+	result.start = time.Now()
+	result.stop = make(chan struct{})
+	result.stopped = make(chan struct{})
+	log.SetOutput(&result)
+	go result.run()
+	return &result
+}
+
+// expect adds files about to be read to the total, so the time left can be estimated
+func (p *progress) expect(files []string) {
+	if p == nil {
+		return
+	}
+	var total int64
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			total += info.Size()
+		}
+	}
+	atomic.AddInt64(&p.total, total)
+}
+
+func (p *progress) run() {
+	defer close(p.stopped)
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.mutex.Lock()
+			p.line = p.render()
+			fmt.Fprintf(p.out, "\r\x1b[K%v", p.line)
+			p.mutex.Unlock()
+		}
+	}
+}
+
+// render describes the files being read, the rate at which lines are read and the time left
+func (p *progress) render() string {
+	var read, lines int64
+	active := make([]string, 0)
+	p.im.statsMutex.Lock()
+	for _, st := range p.im.stats {
+		r := atomic.LoadInt64(&st.read)
+		if st.isDone() {
+			r = st.size
+		} else if st.size > 0 {
+			active = append(active, fmt.Sprintf("%v %v/%v (%v%%)", filepath.Base(st.file), formatBytes(r), formatBytes(st.size), 100*r/st.size))
+		}
+		read += r
+		lines += atomic.LoadInt64(&st.lines)
+	}
+	p.im.statsMutex.Unlock()
+
+	elapsed := time.Since(p.start)
+	parts := make([]string, 0, 3)
+	if len(active) > 0 {
+		parts = append(parts, strings.Join(active, ", "))
+	}
+	parts = append(parts, fmt.Sprintf("%.0f lines/s", float64(lines)/elapsed.Seconds()))
+	total := atomic.LoadInt64(&p.total)
+	if read > 0 && total > read {
+		left := time.Duration(float64(elapsed) * float64(total-read) / float64(read))
+		parts = append(parts, "ETA "+left.Round(time.Second).String())
+	}
+	return strings.Join(parts, " | ")
+}
+
+// Write writes a log message above the progress line
+func (p *progress) Write(b []byte) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	fmt.Fprint(p.out, "\r\x1b[K")
+	n, err := p.out.Write(b)
+	if p.line != "" {
+		fmt.Fprint(p.out, p.line)
+	}
+	return n, err
+}
+
+// finish removes the progress line and sends log messages straight to standard error again
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	<-p.stopped
+	p.mutex.Lock()
+	fmt.Fprint(p.out, "\r\x1b[K")
+	p.line = ""
+	p.mutex.Unlock()
+	log.SetOutput(os.Stderr)
+}
+
+// formatBytes returns a size in bytes in the largest unit in which it is at least 1
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%vB", n)
+	}
+	value := float64(n)
+	suffixes := "KMGTPE"
+	i := -1
+	for value >= unit && i < len(suffixes)-1 {
+		value /= unit
+		i++
+	}
+	return fmt.Sprintf("%.1f%ciB", value, suffixes[i])
+}
+
+// countingReader counts the bytes read through it into a file's stats
+type countingReader struct {
+	r     io.Reader
+	stats *fileStats
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	atomic.AddInt64(&c.stats.read, int64(n))
+	return n, err
+}
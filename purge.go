@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"time"
+
+	"github.com/infodancer/implog/config"
+	"github.com/infodancer/implog/logstore"
+	"github.com/infodancer/implog/retention"
+)
+
+// purge deletes entries older than the retention age of their log, then anything left unused by them
+func purge(args []string) int {
+	flags := newFlagSet("purge", "[-name log] [-older-than age | -policy file | -config file] [-missing-files] [flags]")
+	stores := storeFlags(flags)
+	logname := flags.String("name", "", "The name of the log to purge (defaults to every log)")
+	olderThan := flags.String("older-than", "", "Delete entries older than this, in days (400d), weeks (8w) or a duration (36h), overriding the policy file")
+	policyFile := flags.String("policy", "", "A YAML file setting a default age and the age of each log; logs without one are kept")
+	configFile := flags.String("config", "", "A configuration file whose sites set their own retention ages, and whose store is purged")
+	orphans := flags.Bool("orphans", true, "Delete the paths, referrers, addresses and user agents no longer used by any entry")
+	missingFiles := flags.Bool("missing-files", false, "Forget imported log files that no longer exist, as seen from this machine")
+	flags.Parse(args)
+
+	policy := &retention.Policy{}
+	if len(*policyFile) > 0 {
+		var err error
+		policy, err = retention.Load(*policyFile)
+		if err != nil {
+			log.Println(err)
+			return exitFailure
+		}
+	}
+	var cfg *config.Config
+	if len(*configFile) > 0 {
+		var err error
+		cfg, err = config.Load(*configFile)
+		if err != nil {
+			log.Println(err)
+			return exitFailure
+		}
+		if len(*policyFile) == 0 {
+			policy = &retention.Policy{Logs: make(map[string]retention.Age)}
+			for _, site := range cfg.Sites {
+				policy.Logs[site.Name] = site.Retention
+			}
+		}
+	}
+	if len(*olderThan) > 0 {
+		age, err := retention.ParseAge(*olderThan)
+		if err != nil {
+			log.Println(err)
+			return exitUsage
+		}
+		policy = &retention.Policy{Default: retention.Age(age)}
+	}
+
+	var store logstore.LogStore
+	var err error
+	if cfg != nil {
+		store, err = openConfigStore(cfg.Store)
+	} else {
+		store, err = stores.open()
+	}
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	ctx := context.Background()
+	err = store.Init(ctx)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer store.Close()
+
+	// With one age for every log, the store can purge them all together, dropping whole partitions
+	lognames := []string{*logname}
+	if len(*logname) == 0 && len(policy.Logs) > 0 {
+		lognames, err = store.LogNames(ctx)
+		if err != nil {
+			log.Println(err)
+			return exitFailure
+		}
+	}
+	// Every entry, path, file and so on removed counts towards the exit code; nothing removed is nothing to do
+	removed := 0
+	failed := false
+	now := time.Now()
+	for _, name := range lognames {
+		age := policy.For(name)
+		if age == 0 {
+			continue
+		}
+		before := now.Add(-age)
+		count, err := store.PurgeEntries(ctx, name, before)
+		if len(name) == 0 {
+			name = "every log"
+		}
+		removed += count
+		if err != nil {
+			log.Printf("%v: %v", name, err)
+			failed = true
+			continue
+		}
+		log.Printf("%v: deleted %v entries from before %v\n", name, count, before.UTC().Format(time.RFC3339))
+	}
+
+	if *orphans {
+		count, err := store.PurgeOrphans(ctx)
+		removed += count
+		if err != nil {
+			return exitStatus(removed, err)
+		}
+		log.Printf("Deleted %v unused paths, referrers, addresses and user agents\n", count)
+	}
+	if *missingFiles {
+		count, err := store.PurgeLogFiles(ctx, fileExists)
+		removed += count
+		if err != nil {
+			return exitStatus(removed, err)
+		}
+		log.Printf("Forgot %v log files no longer on disk\n", count)
+	}
+	if failed {
+		return exitPartial
+	}
+	if removed == 0 {
+		return exitNothing
+	}
+	return exitOK
+}
+
+// fileExists reports whether a log file is still on disk, either as it was recorded or compressed.
+// Anything other than a definite absence counts as present, so an unreadable directory loses nothing.
+func fileExists(filename string) bool {
+	for _, name := range []string{filename, filename + ".gz"} {
+		_, err := os.Stat(name)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return true
+		}
+	}
+	return false
+}
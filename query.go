@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/infodancer/implog/report"
+)
+
+// Tables printed by query besides the top-N tables
+const (
+	queryHits     = "hits"
+	queryStatuses = "statuses"
+	queryLogs     = "logs"
+)
+
+// query prints one table of statistics: hits by period, one of the top-N tables, the status codes or the logs in the store
+func query(args []string) int {
+	tables := append([]string{queryHits, queryStatuses, queryLogs}, report.Tables...)
+	synopsis := strings.Join(tables, "|") + " [-name log] [-from day] [-to day] [-format text|csv|json] [flags]"
+	flags := newFlagSet("query", synopsis)
+	stores := storeFlags(flags)
+	newFilter := reportFlags(flags)
+	period := flags.String("by", report.Day, "The period by which hits are counted (valid: "+strings.Join(report.Periods, ", ")+")")
+	limit := flags.Int("limit", report.DefaultLimit, "The number of rows in a top-N table")
+	format := flags.String("format", report.Text, "The output format (valid: text, csv, json)")
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		flags.Parse(args)
+		flags.Usage()
+		return exitUsage
+	}
+	table := args[0]
+	flags.Parse(args[1:])
+
+	filter, err := newFilter()
+	if err != nil {
+		log.Println(err)
+		return exitUsage
+	}
+
+	store, err := stores.openInit(context.Background())
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	section := report.Section{Key: table}
+	switch table {
+	case queryHits:
+		section.Key = *period
+		section.Rows, err = store.Hits(ctx, filter, *period)
+	case queryStatuses:
+		section.Key = "status"
+		section.Rows, err = store.Statuses(ctx, filter)
+	case queryLogs:
+		section.Key = "logname"
+		var names []string
+		names, err = store.LogNames(ctx)
+		if err == nil && strings.ToLower(*format) == report.Text {
+			// A list of names needs no table
+			for _, name := range names {
+				fmt.Println(name)
+			}
+			return exitOK
+		}
+		for _, name := range names {
+			section.Rows = append(section.Rows, report.Count{Key: name})
+		}
+	default:
+		if !isTopTable(table) {
+			fmt.Fprintf(os.Stderr, "unknown table %q: use %v\n", table, strings.Join(tables, ", "))
+			return exitUsage
+		}
+		section.Rows, err = store.Top(ctx, filter, table, *limit)
+	}
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	err = section.Write(os.Stdout, *format)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// isTopTable reports whether a name is one of the top-N tables
+func isTopTable(name string) bool {
+	for _, t := range report.Tables {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+package referrer
+
+import (
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// Referrer classes
+const (
+	// Direct means there was no referrer
+	Direct = "direct"
+	// Internal is a referral from one of the site's own pages
+	Internal = "internal"
+	// Search is a referral from a search engine
+	Search = "search"
+	// Social is a referral from a social network or link aggregator
+	Social = "social"
+	// Email is a referral from a webmail client
+	Email = "email"
+	// External is any other referral
+	External = "external"
+)
+
+// MaxSearchTermsLength is the longest search phrase that is kept
+const MaxSearchTermsLength = 255
+
+// MaxHostLength is the longest host name that is kept
+const MaxHostLength = 255
+
+// Referrer is a referring URL split into its parts and classified
+type Referrer struct {
+	Scheme      string
+	Host        string
+	Path        string
+	Class       string
+	SearchTerms string
+}
+
+// searchEngine identifies a search engine by a label in its host name, and names the parameters holding the query
+type searchEngine struct {
+	label  string
+	params []string
+}
+
+var searchEngines = []searchEngine{
+	{"google", []string{"q", "as_q"}},
+	{"bing", []string{"q"}},
+	{"yahoo", []string{"p", "q"}},
+	{"duckduckgo", []string{"q"}},
+	{"yandex", []string{"text"}},
+	{"baidu", []string{"wd", "word"}},
+	{"ecosia", []string{"q"}},
+	{"startpage", []string{"query", "q"}},
+	{"qwant", []string{"q"}},
+	{"brave", []string{"q"}},
+	{"aol", []string{"q", "query"}},
+	{"naver", []string{"query"}},
+	{"seznam", []string{"q"}},
+	{"kagi", []string{"q"}},
+}
+
+var socialDomains = []string{
+	"facebook.com", "fb.com", "fb.me", "t.co", "twitter.com", "x.com", "linkedin.com", "lnkd.in", "reddit.com",
+	"instagram.com", "pinterest.com", "youtube.com", "youtu.be", "tiktok.com", "news.ycombinator.com", "vk.com",
+	"tumblr.com", "threads.net", "bsky.app", "mastodon.social", "quora.com", "whatsapp.com", "telegram.org", "discord.com",
+}
+
+var emailDomains = []string{
+	"mail.google.com", "outlook.live.com", "outlook.office.com", "outlook.office365.com", "mail.yahoo.com",
+	"mail.aol.com", "mail.proton.me", "mail.zoho.com", "webmail.", "mail.", "com.google.android.gm",
+}
+
+// Classifier splits and classifies referrers, treating referrals from the site's own domains as internal
+type Classifier struct {
+	own []string
+}
+
+// NewClassifier creates a classifier for a site with the given domains; subdomains of them are also treated as internal
+func NewClassifier(ownDomains []string) *Classifier {
+	result := Classifier{}
+	for _, d := range ownDomains {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d != "" {
+			result.own = append(result.own, strings.TrimPrefix(d, "www."))
+		}
+	}
+	return &result
+}
+
+// Classify splits a referrer into scheme, host and path, decides where it came from
+// and extracts the search phrase from search engine referrals
+func (c *Classifier) Classify(raw string) Referrer {
+	result := Referrer{Class: Direct}
+	if raw == "" || raw == "-" {
+		return result
+	}
+	result.Class = External
+	u, err := url.Parse(raw)
+	if err != nil {
+		return result
+	}
+	result.Scheme = strings.ToLower(u.Scheme)
+	result.Host = truncate(strings.ToLower(u.Hostname()), MaxHostLength)
+	result.Path = u.Path
+	host := strings.TrimPrefix(result.Host, "www.")
+
+	switch {
+	case host == "":
+	case matchesDomain(host, c.own):
+		result.Class = Internal
+	case matchesDomain(host, emailDomains):
+		result.Class = Email
+	case matchesDomain(host, socialDomains):
+		result.Class = Social
+	default:
+		if engine := findSearchEngine(host); engine != nil {
+			result.Class = Search
+			result.SearchTerms = searchTerms(u, engine)
+		}
+	}
+	return result
+}
+
+// matchesDomain reports whether a host is one of the domains or a subdomain of one;
+// a domain ending in a dot, such as "mail.", matches any host that starts with it
+func matchesDomain(host string, domains []string) bool {
+	for _, d := range domains {
+		if strings.HasSuffix(d, ".") {
+			if strings.HasPrefix(host, d) {
+				return true
+			}
+			continue
+		}
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// findSearchEngine finds the search engine whose label appears as the registered name in a host,
+// so that www.google.co.uk and search.yahoo.com match but google.example.com does not
+func findSearchEngine(host string) *searchEngine {
+	labels := strings.Split(host, ".")
+	for i := range searchEngines {
+		engine := &searchEngines[i]
+		for j, label := range labels {
+			if label != engine.label {
+				continue
+			}
+			// Allow for a two part public suffix such as co.uk or com.au
+			rest := len(labels) - j - 1
+			if rest == 1 || (rest == 2 && len(labels[j+1]) <= 3) {
+				return engine
+			}
+		}
+	}
+	return nil
+}
+
+// searchTerms extracts the search phrase from a search engine referral, if the engine passed it on
+func searchTerms(u *url.URL, engine *searchEngine) string {
+	query := u.Query()
+	if u.Fragment != "" {
+		// Some engines put the query in the fragment
+		fragment, err := url.ParseQuery(u.Fragment)
+		if err == nil {
+			for k, v := range fragment {
+				query[k] = append(query[k], v...)
+			}
+		}
+	}
+	for _, p := range engine.params {
+		terms := strings.Join(strings.Fields(query.Get(p)), " ")
+		if terms != "" {
+			return truncate(terms, MaxSearchTermsLength)
+		}
+	}
+	return ""
+}
+
+// truncate shortens a string to at most n bytes without splitting a character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/infodancer/implog/referrer"
+)
+
+// classifyReferrers splits and classifies stored referrers,
+// normally for referrers imported before classification existed or after the site's domains have changed
+func classifyReferrers(args []string) int {
+	flags := newFlagSet("referrers", "-name site [-own-domains list] [-all] [flags]")
+	stores := storeFlags(flags)
+	logname := flags.String("name", "", "The name of the site (usually, the hostname of the virtual host), whose referrals are internal")
+	ownDomains := flags.String("own-domains", "", "A comma separated list of the site's other domains, whose referrals are internal")
+	all := flags.Bool("all", false, "Classify every referrer again, not just those that have not been classified")
+	flags.Parse(args)
+
+	store, err := stores.open()
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	store.SetReferrerClassifier(referrer.NewClassifier(siteDomains(*logname, *ownDomains)))
+	err = store.Init(context.Background())
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer store.Close()
+
+	count, err := store.ClassifyReferrers(context.Background(), *all)
+	log.Printf("Classified %v referrers\n", count)
+	return exitStatus(count, err)
+}
@@ -0,0 +1,250 @@
+package rejects
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxSize is the size at which a reject file is rotated if no other size is given
+const DefaultMaxSize = 64 << 20
+
+const filePrefix = "rejects-"
+const fileSuffix = ".jsonl"
+
+// Record describes a single log line that could not be imported
+type Record struct {
+	Time     time.Time `json:"time"`
+	LogName  string    `json:"logname"`
+	LogType  string    `json:"logtype"`
+	File     string    `json:"file"`
+	Modified time.Time `json:"modified"`
+	Line     int64     `json:"line"`
+	Column   int       `json:"column,omitempty"`
+	Reason   string    `json:"reason"`
+	Error    string    `json:"error"`
+	Text     string    `json:"text"`
+}
+
+// Writer appends records as JSON lines to files in a directory, starting a new file whenever the current one reaches its maximum size.
+// Files are only created once there is something to write to them.
+type Writer struct {
+	dir     string
+	maxSize int64
+	mutex   *sync.Mutex
+	file    *os.File
+	buf     *bufio.Writer
+	size    int64
+	seq     int
+	// err is the first error met while writing, reported by Sync so that no record is lost unnoticed
+	err error
+}
+
+// NewWriter creates a writer for reject files in dir, creating the directory if necessary
+func NewWriter(dir string, maxSize int64) (*Writer, error) {
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	result := Writer{}
+	result.dir = dir
+	result.maxSize = maxSize
+	result.mutex = &sync.Mutex{}
+	return &result, nil
+}
+
+// Write appends a record to the current reject file
+func (w *Writer) Write(r Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	err = w.write(data)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return err
+}
+
+func (w *Writer) write(data []byte) error {
+	var err error
+	if w.file != nil && w.size+int64(len(data)) > w.maxSize {
+		err = w.closeFile()
+		if err != nil {
+			return err
+		}
+	}
+	if w.file == nil {
+		err = w.openFile()
+		if err != nil {
+			return err
+		}
+	}
+	n, err := w.buf.Write(data)
+	w.size += int64(n)
+	return err
+}
+
+// Sync flushes the current reject file, if any, and commits it to stable storage,
+// so that the records written so far survive a crash. It fails if any record could not be written.
+func (w *Writer) Sync() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	if w.file == nil {
+		return nil
+	}
+	err := w.buf.Flush()
+	if err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Close flushes and closes the current reject file, if any
+func (w *Writer) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	return w.closeFile()
+}
+
+func (w *Writer) openFile() error {
+	var err error
+	for {
+		w.seq++
+		name := fmt.Sprintf("%v%v-%03d%v", filePrefix, time.Now().Format("20060102-150405"), w.seq, fileSuffix)
+		w.file, err = os.OpenFile(filepath.Join(w.dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		break
+	}
+	w.buf = bufio.NewWriter(w.file)
+	w.size = 0
+	return nil
+}
+
+func (w *Writer) closeFile() error {
+	err := w.buf.Flush()
+	if err == nil {
+		err = w.file.Sync()
+	}
+	cerr := w.file.Close()
+	w.file = nil
+	w.buf = nil
+	if err != nil {
+		return err
+	}
+	return cerr
+}
+
+// Files lists the reject files in a directory, oldest first
+func Files(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), filePrefix) || !strings.HasSuffix(e.Name(), fileSuffix) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, e.Name()))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// ReadFile calls fn for each record in a reject file, stopping at the first error
+func ReadFile(path string, fn func(Record) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	var lc int64
+	for scanner.Scan() {
+		lc++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var r Record
+		err = json.Unmarshal(scanner.Bytes(), &r)
+		if err != nil {
+			return fmt.Errorf("%v line %v: %w", path, lc, err)
+		}
+		err = fn(r)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Counter tallies rejected lines by reason
+type Counter struct {
+	mutex  *sync.Mutex
+	counts map[string]uint64
+}
+
+// ReasonCount is the number of lines rejected for a single reason
+type ReasonCount struct {
+	Reason string `json:"reason"`
+	Count  uint64 `json:"count"`
+}
+
+// NewCounter creates an empty counter
+func NewCounter() *Counter {
+	result := Counter{}
+	result.mutex = &sync.Mutex{}
+	result.counts = make(map[string]uint64)
+	return &result
+}
+
+// Add counts one rejected line with the given reason
+func (c *Counter) Add(reason string) {
+	c.mutex.Lock()
+	c.counts[reason]++
+	c.mutex.Unlock()
+}
+
+// Counts reports the counts for each reason, most frequent first
+func (c *Counter) Counts() []ReasonCount {
+	c.mutex.Lock()
+	result := make([]ReasonCount, 0, len(c.counts))
+	for reason, count := range c.counts {
+		result = append(result, ReasonCount{Reason: reason, Count: count})
+	}
+	c.mutex.Unlock()
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Reason < result[j].Reason
+	})
+	return result
+}
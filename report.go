@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/infodancer/implog/botclass"
+	"github.com/infodancer/implog/report"
+)
+
+// printReport prints the top URLs, referrers and clients, status codes, bandwidth and error hotspots of a log
+func printReport(args []string) int {
+	flags := newFlagSet("report", "[-name log] [-from day] [-to day] [-format text|csv|json] [flags]")
+	stores := storeFlags(flags)
+	newFilter := reportFlags(flags)
+	limit := flags.Int("limit", report.DefaultLimit, "The number of rows in each top-N table")
+	format := flags.String("format", report.Text, "The output format (valid: text, csv, json)")
+	flags.Parse(args)
+
+	filter, err := newFilter()
+	if err != nil {
+		log.Println(err)
+		return exitUsage
+	}
+
+	store, err := stores.open()
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	err = store.Init(context.Background())
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer store.Close()
+
+	r, err := store.Report(context.Background(), filter, *limit)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	err = r.Write(os.Stdout, *format)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// reportFlags adds the flags that select the entries in a report to a flag set,
+// returning a function that creates the filter from them once the flags have been parsed
+func reportFlags(flags *flag.FlagSet) func() (report.Filter, error) {
+	logname := flags.String("name", "", "The name of the log to report on (defaults to every log)")
+	from := flags.String("from", "", "The first day (YYYY-MM-DD) or time (RFC 3339) to include")
+	to := flags.String("to", "", "The last day (YYYY-MM-DD) to include, or the time (RFC 3339) at which to stop")
+	cidr := flags.String("cidr", "", "The range of client addresses to include, such as 192.0.2.0/24")
+	class := flags.String("class", "", "The traffic class to include, such as human or verified-crawler")
+	return func() (report.Filter, error) {
+		var err error
+		filter := report.Filter{LogName: *logname}
+		filter.From, err = report.ParseTime(*from, false)
+		if err != nil {
+			return filter, err
+		}
+		filter.To, err = report.ParseTime(*to, true)
+		if err != nil {
+			return filter, err
+		}
+		if *cidr != "" {
+			filter.CIDR, err = netip.ParsePrefix(*cidr)
+			if err != nil {
+				return filter, fmt.Errorf("invalid cidr %q: %w", *cidr, err)
+			}
+		}
+		if *class != "" && !botclass.IsClass(*class) {
+			return filter, fmt.Errorf("unknown traffic class %q: use one of %v", *class, strings.Join(botclass.Classes, ", "))
+		}
+		filter.Class = *class
+		return filter, nil
+	}
+}
@@ -0,0 +1,287 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// DefaultLimit is the number of rows in each top-N section when nothing else is specified
+const DefaultLimit = 10
+
+// Output formats
+const (
+	Text = "text"
+	CSV  = "csv"
+	JSON = "json"
+)
+
+// MonthFilter returns a filter covering one calendar month, given as YYYY-MM, of a log
+func MonthFilter(logname string, month string) (Filter, error) {
+	start, err := time.Parse("2006-01", month)
+	if err != nil {
+		return Filter{}, fmt.Errorf("invalid month %q: use YYYY-MM", month)
+	}
+	return Filter{LogName: logname, From: start, To: start.AddDate(0, 1, 0)}, nil
+}
+
+// Top-N tables
+const (
+	URIs         = "uris"
+	Referrers    = "referrers"
+	Clients      = "clients"
+	NotFound     = "notfound"
+	ServerErrors = "errors"
+	Countries    = "countries"
+	Browsers     = "browsers"
+)
+
+// Tables lists the top-N tables
+var Tables = []string{URIs, Referrers, Clients, NotFound, ServerErrors, Countries, Browsers}
+
+// Periods by which requests are counted
+const (
+	Hour  = "hour"
+	Day   = "day"
+	Month = "month"
+	// HourOfDay counts requests by the hour of the day, whatever the day
+	HourOfDay = "hourofday"
+)
+
+// Periods lists the periods by which requests can be counted
+var Periods = []string{Hour, Day, Month, HourOfDay}
+
+// Filter limits a report to one log, a range of request times, a range of client addresses and a traffic class;
+// empty fields are not applied
+type Filter struct {
+	LogName string
+	// From is the earliest request time included
+	From time.Time
+	// To is the first request time after the range
+	To time.Time
+	// CIDR is the range of client addresses included
+	CIDR netip.Prefix
+	// Class is the traffic class of the entries included
+	Class string
+}
+
+// Count is a row of a report: how many requests there were for a key and how many bytes were sent.
+// Counts by period also estimate how many distinct addresses made the requests.
+type Count struct {
+	Key       string `json:"key"`
+	Detail    string `json:"detail,omitempty"`
+	Hits      int64  `json:"hits"`
+	Bytes     int64  `json:"bytes"`
+	UniqueIPs int64  `json:"unique_ips,omitempty"`
+}
+
+// Report holds the statistics for a log over a period
+type Report struct {
+	LogName      string     `json:"logname,omitempty"`
+	From         *time.Time `json:"from,omitempty"`
+	To           *time.Time `json:"to,omitempty"`
+	CIDR         string     `json:"cidr,omitempty"`
+	Class        string     `json:"class,omitempty"`
+	TopURIs      []Count    `json:"top_uris"`
+	TopReferrers []Count    `json:"top_referrers"`
+	Statuses     []Count    `json:"statuses"`
+	Bandwidth    []Count    `json:"bandwidth_by_day"`
+	Hours        []Count    `json:"hours"`
+	TopClients   []Count    `json:"top_clients"`
+	NotFound     []Count    `json:"not_found"`
+	ServerErrors []Count    `json:"server_errors"`
+	Countries    []Count    `json:"countries"`
+	Browsers     []Count    `json:"browsers"`
+}
+
+// Section is a titled table of a report
+type Section struct {
+	Title  string
+	Key    string
+	Detail string
+	Rows   []Count
+}
+
+// New creates an empty report for a filter
+func New(filter Filter) *Report {
+	result := Report{}
+	result.LogName = filter.LogName
+	if !filter.From.IsZero() {
+		from := filter.From
+		result.From = &from
+	}
+	if !filter.To.IsZero() {
+		to := filter.To
+		result.To = &to
+	}
+	if filter.CIDR.IsValid() {
+		result.CIDR = filter.CIDR.String()
+	}
+	result.Class = filter.Class
+	return &result
+}
+
+// Sections lists the tables of the report in the order they are displayed
+func (r *Report) Sections() []Section {
+	return []Section{
+		{"Top URLs", "path", "", r.TopURIs},
+		{"Top referrers", "referrer", "class", r.TopReferrers},
+		{"Status codes", "status", "", r.Statuses},
+		{"Bandwidth by day", "day", "", r.Bandwidth},
+		{"Requests by hour", "hour", "", r.Hours},
+		{"Top clients", "address", "name", r.TopClients},
+		{"Not found (404)", "path", "", r.NotFound},
+		{"Server errors (5xx)", "path", "status", r.ServerErrors},
+		{"Countries", "country", "", r.Countries},
+		{"Browsers", "browser", "", r.Browsers},
+	}
+}
+
+// Write writes the report in one of the output formats
+func (r *Report) Write(w io.Writer, format string) error {
+	switch strings.ToLower(format) {
+	case Text, "":
+		return r.WriteText(w)
+	case CSV:
+		return r.WriteCSV(w)
+	case JSON:
+		return r.WriteJSON(w)
+	}
+	return fmt.Errorf("unknown report format: %v", format)
+}
+
+// WriteText writes the report as a series of aligned tables
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, section := range r.Sections() {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "%v\n", section.Title)
+		// Flush each section so its columns are aligned on their own
+		err := section.writeText(tw)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeText writes the section as an aligned table, with a column of distinct addresses if it has them
+func (s *Section) writeText(tw *tabwriter.Writer) error {
+	addresses := s.hasUniqueIPs()
+	header := "hits\tbytes\t"
+	if addresses {
+		header += "addresses\t"
+	}
+	header += s.Key
+	if s.Detail != "" {
+		header += "\t" + s.Detail
+	}
+	fmt.Fprintln(tw, header+"\t")
+	for _, c := range s.Rows {
+		line := fmt.Sprintf("%v\t%v\t", c.Hits, c.Bytes)
+		if addresses {
+			line += fmt.Sprintf("%v\t", c.UniqueIPs)
+		}
+		line += c.Key
+		if s.Detail != "" {
+			line += "\t" + c.Detail
+		}
+		fmt.Fprintln(tw, line+"\t")
+	}
+	return tw.Flush()
+}
+
+// hasUniqueIPs reports whether any row of the section estimates its distinct addresses
+func (s *Section) hasUniqueIPs() bool {
+	for _, c := range s.Rows {
+		if c.UniqueIPs > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Write writes the rows of the section alone in one of the output formats; JSON is an array of rows
+func (s *Section) Write(w io.Writer, format string) error {
+	switch strings.ToLower(format) {
+	case Text, "":
+		return s.writeText(tabwriter.NewWriter(w, 0, 0, 2, ' ', 0))
+	case CSV:
+		cw := csv.NewWriter(w)
+		err := cw.Write([]string{s.Key, "detail", "hits", "bytes", "unique_ips"})
+		if err != nil {
+			return err
+		}
+		for _, c := range s.Rows {
+			err = cw.Write([]string{c.Key, c.Detail, strconv.FormatInt(c.Hits, 10), strconv.FormatInt(c.Bytes, 10), strconv.FormatInt(c.UniqueIPs, 10)})
+			if err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case JSON:
+		rows := s.Rows
+		if rows == nil {
+			rows = []Count{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	}
+	return fmt.Errorf("unknown report format: %v", format)
+}
+
+// WriteCSV writes the report as a single CSV table, with the section in the first column
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"section", "key", "detail", "hits", "bytes"})
+	if err != nil {
+		return err
+	}
+	for _, section := range r.Sections() {
+		for _, c := range section.Rows {
+			err = cw.Write([]string{section.Title, c.Key, c.Detail, strconv.FormatInt(c.Hits, 10), strconv.FormatInt(c.Bytes, 10)})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes the report as a JSON object
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// ParseTime parses a -from or -to argument, either a date or an RFC 3339 time.
+// A date given as the end of a range includes the whole of that day.
+func ParseTime(s string, end bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	t, err = time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: use YYYY-MM-DD or RFC 3339", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"testing"
+)
+
+func TestReportFlagsClass(t *testing.T) {
+	tests := []struct {
+		class string
+		valid bool
+	}{
+		{"", true},
+		{"human", true},
+		{"verified-crawler", true},
+		{"people", false},
+	}
+	for _, tt := range tests {
+		flags := flag.NewFlagSet("report", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		newFilter := reportFlags(flags)
+		err := flags.Parse([]string{"-class", tt.class})
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter, err := newFilter()
+		if tt.valid && (err != nil || filter.Class != tt.class) {
+			t.Errorf("class %q gave filter class %q and error %v", tt.class, filter.Class, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("class %q was accepted", tt.class)
+		}
+	}
+}
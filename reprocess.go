@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/infodancer/implog/httplog"
+	"github.com/infodancer/implog/rejects"
+)
+
+var errUnsupportedLogType = errors.New("unsupported log type")
+
+// reprocessRejects parses the lines in a reject directory again, normally after a parser fix.
+// Lines that now parse are written to the store; lines that still fail are written to a new reject file
+// and the old reject files are removed.
+func reprocessRejects(args []string) int {
+	flags := newFlagSet("reprocess-rejects", "-rejects dir [flags]")
+	dir := flags.String("rejects", "", "The directory containing the reject files to reprocess")
+	stores := storeFlags(flags)
+	parseModeName := flags.String("parse-mode", "lenient", "How to handle fields that fail validation (strict rejects the line, lenient stores it marked as a parse error)")
+	newPolicy := privacyFlags(flags)
+	flags.Parse(args)
+
+	parseMode, err := httplog.LookupParseMode(*parseModeName)
+	if err != nil {
+		log.Println(err)
+		return exitUsage
+	}
+
+	if len(*dir) == 0 {
+		log.Println("a reject directory must be specified with -rejects")
+		return exitUsage
+	}
+	files, err := rejects.Files(*dir)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	if len(files) == 0 {
+		log.Printf("no reject files found in %v\n", *dir)
+		return exitNothing
+	}
+
+	policy, err := newPolicy()
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+
+	store, err := stores.open()
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	store.SetPrivacyPolicy(policy.String())
+	err = store.Init(context.Background())
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer store.Close()
+
+	im := newImporter(store, parseMode)
+	im.policy = policy
+	im.rejectWriter, err = rejects.NewWriter(*dir, rejects.DefaultMaxSize)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer im.rejectWriter.Close()
+
+	for _, path := range files {
+		err = rejects.ReadFile(path, func(r rejects.Record) error {
+			if !strings.EqualFold(r.LogType, "HTTP") {
+				im.reject(r, errUnsupportedLogType)
+				return nil
+			}
+			entrydata, err := httplog.ParseLogLineMode(r.Text, im.parseMode)
+			if err != nil {
+				im.reject(r, err)
+				return nil
+			}
+			_, err = im.write(entrydata, r.LogName, r.File, r.Modified)
+			if err != nil {
+				im.reject(r, err)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("error reading %v: %v\n", path, err)
+			im.failedFiles++
+			continue
+		}
+		// The lines rejected again must be safely in the new reject file before the old one is removed
+		err = im.rejectWriter.Sync()
+		if err != nil {
+			log.Printf("error writing rejects, keeping %v: %v\n", path, err)
+			im.failedFiles++
+			continue
+		}
+		err = os.Remove(path)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	im.logTotals()
+	return im.exitCode()
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// resolveNames looks up the names of ip addresses that were stored without one,
+// normally because the import ran with -no-rdns or the resolver could not keep up
+func resolveNames(args []string) int {
+	flags := newFlagSet("resolve", "[-all] [flags]")
+	stores := storeFlags(flags)
+	all := flags.Bool("all", false, "Also retry addresses for which no name was found before")
+	newResolver := resolverFlags(flags)
+	flags.Parse(args)
+
+	store, err := stores.open()
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	store.SetResolver(newResolver())
+	err = store.Init(context.Background())
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+
+	count, err := store.ResolveIPNames(context.Background(), *all)
+	log.Printf("Resolving %v addresses...\n", count)
+	// Closing the store waits for the queued lookups to finish
+	store.Close()
+	log.Printf("Finished looking up %v addresses\n", count)
+	return exitStatus(count, err)
+}
@@ -0,0 +1,284 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Unknown is the name recorded for addresses that have no usable PTR record
+const Unknown = "unknown"
+
+// Config describes how reverse lookups are performed
+type Config struct {
+	// Server is the host:port of the DNS server to query; the system resolver is used if it is empty
+	Server string
+	// Workers is the number of lookups performed at once
+	Workers int
+	// QueueSize is the number of addresses that can wait for a worker before new ones are dropped
+	QueueSize int
+	// Timeout limits each lookup, including forward confirmation
+	Timeout time.Duration
+	// NegativeTTL is how long a failed lookup is remembered before the address is tried again
+	NegativeTTL time.Duration
+	// NegativeSize is the most failed lookups remembered at once
+	NegativeSize int
+}
+
+// DefaultConfig returns the configuration used when nothing else is specified
+func DefaultConfig() Config {
+	return Config{Workers: 8, QueueSize: 10000, Timeout: 2 * time.Second, NegativeTTL: time.Hour, NegativeSize: 100000}
+}
+
+// Result is the outcome of a reverse lookup
+type Result struct {
+	// Name is the host name from the PTR record, or Unknown if there is none
+	Name string
+	// Confirmed reports whether a forward lookup of the name includes the address
+	Confirmed bool
+}
+
+// Handler receives the results of queued lookups; the key is whatever was passed to Enqueue
+type Handler func(key string, address string, result Result)
+
+type request struct {
+	key     string
+	address string
+}
+
+type negative struct {
+	expires time.Time
+	err     error
+}
+
+// Resolver performs reverse DNS lookups, either directly or in the background through a pool of workers
+type Resolver struct {
+	config   Config
+	resolver *net.Resolver
+	queue    chan request
+	// done is closed when the resolver stops accepting addresses
+	done     chan struct{}
+	wg       *sync.WaitGroup
+	qMutex   *sync.Mutex
+	mutex    *sync.Mutex
+	failures map[string]negative
+	swept    time.Time
+	started  bool
+	closed   bool
+}
+
+var errClosed = errors.New("resolver is closed")
+
+// New creates a resolver; background lookups do not begin until Start is called
+func New(config Config) *Resolver {
+	defaults := DefaultConfig()
+	if config.Workers <= 0 {
+		config.Workers = defaults.Workers
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaults.QueueSize
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaults.Timeout
+	}
+	if config.NegativeTTL <= 0 {
+		config.NegativeTTL = defaults.NegativeTTL
+	}
+	if config.NegativeSize <= 0 {
+		config.NegativeSize = defaults.NegativeSize
+	}
+	result := Resolver{}
+	result.config = config
+	result.resolver = net.DefaultResolver
+	if config.Server != "" {
+		server := config.Server
+		result.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+				d := net.Dialer{}
+				return d.DialContext(ctx, network, server)
+			},
+		}
+	}
+	result.queue = make(chan request, config.QueueSize)
+	result.done = make(chan struct{})
+	result.wg = &sync.WaitGroup{}
+	result.qMutex = &sync.Mutex{}
+	result.mutex = &sync.Mutex{}
+	result.failures = make(map[string]negative)
+	result.swept = time.Now()
+	return &result
+}
+
+// Lookup finds the name of an address, confirming it with a forward lookup.
+// Addresses without a PTR record resolve to Unknown; an error is only returned for failures that may be temporary,
+// such as timeouts, so that the address can be tried again later.
+func (r *Resolver) Lookup(ctx context.Context, address string) (Result, error) {
+	r.mutex.Lock()
+	failure, ok := r.failures[address]
+	if ok && !time.Now().Before(failure.expires) {
+		delete(r.failures, address)
+		ok = false
+	}
+	r.mutex.Unlock()
+	if ok {
+		if failure.err != nil {
+			return Result{}, failure.err
+		}
+		return Result{Name: Unknown}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.config.Timeout)
+	defer cancel()
+	names, err := r.resolver.LookupAddr(ctx, address)
+	if err != nil || len(names) == 0 {
+		var dnsErr *net.DNSError
+		if err != nil && (!errors.As(err, &dnsErr) || !dnsErr.IsNotFound) {
+			r.remember(address, err)
+			return Result{}, err
+		}
+		r.remember(address, nil)
+		return Result{Name: Unknown}, nil
+	}
+
+	ip := net.ParseIP(address)
+	for _, name := range names {
+		addrs, err := r.resolver.LookupIPAddr(ctx, name)
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if a.IP.Equal(ip) {
+				return Result{Name: strings.TrimSuffix(name, "."), Confirmed: true}, nil
+			}
+		}
+	}
+	return Result{Name: strings.TrimSuffix(names[0], ".")}, nil
+}
+
+// remember records a failed lookup so that it is not repeated until the negative cache entry expires
+func (r *Resolver) remember(address string, err error) {
+	now := time.Now()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.failures) >= r.config.NegativeSize || now.Sub(r.swept) >= r.config.NegativeTTL {
+		r.sweep(now)
+	}
+	r.failures[address] = negative{expires: now.Add(r.config.NegativeTTL), err: err}
+}
+
+// sweep removes the expired failures from the negative cache. If it is still full, some failures are
+// forgotten early, leaving room for new ones, so that a log full of unresolvable addresses cannot grow it
+// without limit. The caller must hold the mutex.
+func (r *Resolver) sweep(now time.Time) {
+	for address, failure := range r.failures {
+		if !now.Before(failure.expires) {
+			delete(r.failures, address)
+		}
+	}
+	for address := range r.failures {
+		if len(r.failures) < r.config.NegativeSize*3/4 {
+			break
+		}
+		delete(r.failures, address)
+	}
+	r.swept = now
+}
+
+// Start begins background lookups, passing each result to the handler.
+// Lookups that fail temporarily are not passed on, leaving them to be retried later.
+func (r *Resolver) Start(handler Handler) {
+	r.qMutex.Lock()
+	defer r.qMutex.Unlock()
+	if r.started || r.closed {
+		return
+	}
+	r.started = true
+	for i := 0; i < r.config.Workers; i++ {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			for {
+				select {
+				case req := <-r.queue:
+					r.handle(req, handler)
+				case <-r.done:
+					// Finish the lookups already queued
+					for {
+						select {
+						case req := <-r.queue:
+							r.handle(req, handler)
+						default:
+							return
+						}
+					}
+				}
+			}
+		}()
+	}
+}
+
+// handle looks up a queued address, passing the result on unless the lookup failed temporarily
+func (r *Resolver) handle(req request, handler Handler) {
+	result, err := r.Lookup(context.Background(), req.address)
+	if err != nil {
+		return
+	}
+	handler(req.key, req.address, result)
+}
+
+// isClosed reports whether Close has been called
+func (r *Resolver) isClosed() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// TryEnqueue queues an address for a background lookup without waiting, reporting whether it was queued.
+// Addresses are dropped when the queue is full, so that a slow resolver never holds up the caller.
+func (r *Resolver) TryEnqueue(key string, address string) bool {
+	if r.isClosed() {
+		return false
+	}
+	select {
+	case r.queue <- request{key: key, address: address}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Enqueue queues an address for a background lookup, waiting for room in the queue if necessary.
+// An address queued while the resolver is closing may be dropped.
+func (r *Resolver) Enqueue(ctx context.Context, key string, address string) error {
+	if r.isClosed() {
+		return errClosed
+	}
+	select {
+	case r.queue <- request{key: key, address: address}:
+		return nil
+	case <-r.done:
+		return errClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting addresses and waits for the queued lookups to finish
+func (r *Resolver) Close() {
+	r.qMutex.Lock()
+	if r.closed {
+		r.qMutex.Unlock()
+		return
+	}
+	r.closed = true
+	close(r.done)
+	r.qMutex.Unlock()
+	r.wg.Wait()
+}
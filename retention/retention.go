@@ -0,0 +1,93 @@
+package retention
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Forever exempts a log from the default age, keeping its entries indefinitely
+const Forever = "forever"
+
+// Policy sets how long the entries of each log are kept
+type Policy struct {
+	// Default applies to logs not listed in Logs; zero keeps them indefinitely
+	Default Age `yaml:"default"`
+	// Logs sets the age of each log by name
+	Logs map[string]Age `yaml:"logs"`
+}
+
+// Age is how long entries are kept, written as a number of days (400d), weeks (8w) or a Go duration (36h)
+type Age time.Duration
+
+// ParseAge parses an age; Forever, or an empty string, is zero
+func ParseAge(s string) (time.Duration, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	if s == "" || s == Forever {
+		return 0, nil
+	}
+	days := 0
+	switch {
+	case strings.HasSuffix(s, "d"):
+		days = 1
+	case strings.HasSuffix(s, "w"):
+		days = 7
+	}
+	var d time.Duration
+	if days > 0 {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid age %q: use days (400d), weeks (8w) or a duration (36h)", s)
+		}
+		d = time.Duration(n*days) * 24 * time.Hour
+	} else {
+		var err error
+		d, err = time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid age %q: use days (400d), weeks (8w) or a duration (36h)", s)
+		}
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid age %q: must be positive, or %v", s, Forever)
+	}
+	return d, nil
+}
+
+// UnmarshalYAML reads an age written as a string
+func (a *Age) UnmarshalYAML(value *yaml.Node) error {
+	d, err := ParseAge(value.Value)
+	if err != nil {
+		return err
+	}
+	*a = Age(d)
+	return nil
+}
+
+// Load reads a policy from a YAML file
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	result := Policy{}
+	err = yaml.Unmarshal(data, &result)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	if result.Default == 0 && len(result.Logs) == 0 {
+		return nil, fmt.Errorf("%v: no default age or logs found", path)
+	}
+	return &result, nil
+}
+
+// For returns how long the entries of a log are kept, or zero to keep them indefinitely
+func (p *Policy) For(logname string) time.Duration {
+	if age, ok := p.Logs[logname]; ok {
+		return time.Duration(age)
+	}
+	return time.Duration(p.Default)
+}
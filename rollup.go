@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+)
+
+// rollup maintains the hourly and daily rollups; its only action so far is rebuild
+func rollup(args []string) int {
+	if len(args) == 0 || args[0] != "rebuild" {
+		fmt.Fprintln(os.Stderr, "usage: implog rollup rebuild [-name log] [-from day] [-to day] [-pending] [flags]")
+		if len(args) > 0 && isHelp(args[0]) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	return rebuildRollups(args[1:])
+}
+
+// rebuildRollups recalculates the rollups of the days covered by the filter from the stored entries,
+// filling them in for entries imported before rollups were kept, or correcting them after a failure
+func rebuildRollups(args []string) int {
+	flags := newFlagSet("rollup rebuild", "[-name log] [-from day] [-to day] [-pending] [flags]")
+	stores := storeFlags(flags)
+	newFilter := reportFlags(flags)
+	pending := flags.Bool("pending", false, "Rebuild only the days whose rollups an import did not finish writing")
+	flags.Parse(args)
+
+	filter, err := newFilter()
+	if err != nil {
+		log.Println(err)
+		return exitUsage
+	}
+
+	store, err := stores.openInit(context.Background())
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer store.Close()
+
+	var count int
+	if *pending {
+		count, err = store.RebuildPendingRollups(context.Background())
+	} else {
+		count, err = store.RebuildRollups(context.Background(), filter)
+	}
+	log.Printf("Rebuilt the rollups of %v days\n", count)
+	return exitStatus(count, err)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/infodancer/implog/logstore"
+	"github.com/infodancer/implog/logstore/mysql"
+)
+
+const schemaUsage = "usage: implog schema print|migrate [flags]"
+
+// schemaCommand prints the SQL schema, or brings a database up to it
+func schemaCommand(args []string) int {
+	if len(args) == 0 || isHelp(args[0]) {
+		fmt.Fprintln(os.Stderr, schemaUsage)
+		if len(args) > 0 {
+			return exitOK
+		}
+		return exitUsage
+	}
+	switch args[0] {
+	case "print":
+		return printSchema(args[1:])
+	case "migrate":
+		return migrateSchema(args[1:])
+	}
+	fmt.Fprintln(os.Stderr, schemaUsage)
+	return exitUsage
+}
+
+// printSchema writes the SQL creating the tables of the latest schema, without connecting to a database,
+// for review or for creating the tables by hand
+func printSchema(args []string) int {
+	flags := newFlagSet("schema print", "[-dbdriver driver]")
+	dbdriver := flags.String("dbdriver", "mysql", "The type of database whose schema is printed (defaults to mysql)")
+	flags.Parse(args)
+
+	var store logstore.LogStore
+	if *dbdriver == "mysql" {
+		// The store is never opened, so it needs no connection
+		s, err := mysql.New(*dbdriver, "")
+		if err != nil {
+			log.Println(err)
+			return exitFailure
+		}
+		store = s
+	} else {
+		log.Printf("unrecognized logstore type: %v\n", *dbdriver)
+		return exitUsage
+	}
+	err := store.PrintSchema(os.Stdout)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// migrateSchema creates the tables of a new database, or applies the migrations an existing one has not seen,
+// without importing anything
+func migrateSchema(args []string) int {
+	flags := newFlagSet("schema migrate", "[flags]")
+	stores := storeFlags(flags)
+	flags.Parse(args)
+
+	store, err := stores.open()
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	ctx := context.Background()
+	before, err := store.SchemaVersion(ctx)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	err = store.Init(ctx)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer store.Close()
+	after, err := store.SchemaVersion(ctx)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	if after == before {
+		log.Printf("The schema is already at version %v\n", after)
+		return exitNothing
+	}
+	if before == 0 {
+		log.Printf("Created the tables at schema version %v\n", after)
+	} else {
+		log.Printf("Migrated the schema from version %v to %v\n", before, after)
+	}
+	return exitOK
+}
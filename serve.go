@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/infodancer/implog/dashboard"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// serveDashboard runs an HTTP server with a dashboard and a JSON API over the store until interrupted
+func serveDashboard(args []string) int {
+	flags := newFlagSet("serve", "-access file [-listen address] [flags]")
+	stores := storeFlags(flags)
+	listen := flags.String("listen", ":8080", "The address on which to listen for HTTP requests")
+	accessFile := flags.String("access", "", "The YAML file listing the users and tokens allowed in, and the logs each may read")
+	metricsListen := flags.String("metrics-listen", "", "An address, such as :9100, on which to serve Prometheus metrics at /metrics")
+	flags.Parse(args)
+
+	if len(*accessFile) == 0 {
+		log.Println("a credentials file must be specified with -access")
+		return exitUsage
+	}
+	access, err := dashboard.LoadAccess(*accessFile)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+
+	store, err := stores.open()
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	err = store.Init(context.Background())
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer store.Close()
+
+	if len(*metricsListen) > 0 {
+		registry := prometheus.NewRegistry()
+		registerStoreMetrics(registry, store)
+		stopMetrics, err := listenMetrics(*metricsListen, registry)
+		if err != nil {
+			log.Println(err)
+			return exitFailure
+		}
+		defer stopMetrics()
+	}
+
+	server := &http.Server{
+		Addr:              *listen,
+		Handler:           dashboard.New(store, access),
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      5 * time.Minute,
+		IdleTimeout:       2 * time.Minute,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+	log.Printf("Listening on %v\n", *listen)
+	err = server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Println(err)
+		return exitFailure
+	}
+	return exitOK
+}
@@ -0,0 +1,94 @@
+package session
+
+import (
+	"time"
+)
+
+// DefaultTimeout is the period of inactivity after which a visitor's next request starts a new session
+const DefaultTimeout = 30 * time.Minute
+
+// Hit is a single request to be assigned to a session
+type Hit struct {
+	// Visitor identifies who made the request, such as a session cookie or an address and user agent
+	Visitor string
+	LogName string
+	Time    time.Time
+	URI     string
+	Bytes   int64
+}
+
+// Session is a visit: a run of requests from one visitor with no gap longer than the timeout
+type Session struct {
+	ID       string
+	Visitor  string
+	LogName  string
+	Start    time.Time
+	End      time.Time
+	EntryURI string
+	ExitURI  string
+	Hits     int64
+	Bytes    int64
+}
+
+// Bounce reports whether the visit consisted of a single request
+func (s *Session) Bounce() bool {
+	return s.Hits == 1
+}
+
+// Tracker groups hits into sessions. Hits should be added in time order; a hit slightly earlier than
+// the session it belongs to (within the timeout) still joins it, moving its start and entry page.
+type Tracker struct {
+	timeout time.Duration
+	newID   func() string
+	open    map[string]*Session
+}
+
+// NewTracker creates a tracker with the given inactivity timeout, naming new sessions with newID
+func NewTracker(timeout time.Duration, newID func() string) *Tracker {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	result := Tracker{}
+	result.timeout = timeout
+	result.newID = newID
+	result.open = make(map[string]*Session)
+	return &result
+}
+
+// Resume adds a session recorded earlier, so that later hits from the same visitor can extend it
+func (t *Tracker) Resume(s *Session) {
+	current := t.open[s.Visitor]
+	if current == nil || s.End.After(current.End) {
+		t.open[s.Visitor] = s
+	}
+}
+
+// Add assigns a hit to the visitor's open session, or to a new one, and returns the session
+func (t *Tracker) Add(h Hit) *Session {
+	s := t.open[h.Visitor]
+	if s != nil && !h.Time.After(s.End.Add(t.timeout)) && !h.Time.Before(s.Start.Add(-t.timeout)) {
+		s.Hits++
+		s.Bytes += h.Bytes
+		if h.Time.Before(s.Start) {
+			s.Start = h.Time
+			s.EntryURI = h.URI
+		}
+		if !h.Time.Before(s.End) {
+			s.End = h.Time
+			s.ExitURI = h.URI
+		}
+		return s
+	}
+	s = &Session{ID: t.newID(), Visitor: h.Visitor, LogName: h.LogName, Start: h.Time, End: h.Time, EntryURI: h.URI, ExitURI: h.URI, Hits: 1, Bytes: h.Bytes}
+	t.open[h.Visitor] = s
+	return s
+}
+
+// Expire forgets the sessions that no hit at or after now could extend
+func (t *Tracker) Expire(now time.Time) {
+	for visitor, s := range t.open {
+		if now.Sub(s.End) > t.timeout {
+			delete(t.open, visitor)
+		}
+	}
+}
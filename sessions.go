@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/infodancer/implog/session"
+)
+
+// buildSessions groups newly imported entries into sessions, extending sessions left open by the previous run,
+// so it can be run after each import
+func buildSessions(args []string) int {
+	flags := newFlagSet("sessions", "[-name log] [-timeout duration] [-cookie] [flags]")
+	stores := storeFlags(flags)
+	logname := flags.String("name", "", "The name of the log whose entries are grouped (defaults to every log)")
+	timeout := flags.Duration("timeout", session.DefaultTimeout, "The period of inactivity after which a visitor's next request starts a new session")
+	cookie := flags.Bool("cookie", false, "Identify visitors by the session cookie logged after the user agent, where there is one, instead of by address and user agent")
+	flags.Parse(args)
+
+	store, err := stores.openInit(context.Background())
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer store.Close()
+
+	count, err := store.Sessionize(context.Background(), *logname, *timeout, *cookie)
+	log.Printf("Grouped %v entries into sessions\n", count)
+	return exitStatus(count, err)
+}
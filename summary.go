@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/infodancer/implog/latency"
+	"github.com/infodancer/implog/rejects"
+)
+
+// importSummary describes an import for monitoring, such as a cron job alerting when the rejects or latency are unusual
+type importSummary struct {
+	Started  time.Time     `json:"started"`
+	Finished time.Time     `json:"finished"`
+	Duration float64       `json:"duration_seconds"`
+	DryRun   bool          `json:"dry_run"`
+	ExitCode int           `json:"exit_code"`
+	Files    []fileSummary `json:"files"`
+	Totals   importTotals  `json:"totals"`
+	// Rejects counts the lines that could not be parsed by reason, and BadFields the lines stored with each invalid field
+	Rejects      []rejects.ReasonCount `json:"rejects"`
+	BadFields    []rejects.ReasonCount `json:"bad_fields"`
+	StoreLatency latency.Summary       `json:"store_latency"`
+}
+
+// fileSummary describes what became of one log file
+type fileSummary struct {
+	File        string           `json:"file"`
+	LogName     string           `json:"logname"`
+	Skipped     bool             `json:"skipped"`
+	Compressed  bool             `json:"compressed"`
+	Size        int64            `json:"size"`
+	Lines       int64            `json:"lines"`
+	Parsed      int64            `json:"parsed"`
+	Rejected    int64            `json:"rejected"`
+	Inserted    int64            `json:"inserted"`
+	Duplicates  int64            `json:"duplicates"`
+	Errors      int64            `json:"errors"`
+	Formats     map[string]int64 `json:"formats"`
+	Duration    float64          `json:"duration_seconds"`
+	LinesPerSec float64          `json:"lines_per_second"`
+	Error       string           `json:"error,omitempty"`
+}
+
+// importTotals adds up the files of an import
+type importTotals struct {
+	Files      int   `json:"files"`
+	Skipped    int   `json:"skipped"`
+	Failed     int   `json:"failed"`
+	Lines      int64 `json:"lines"`
+	Parsed     int64 `json:"parsed"`
+	Rejected   int64 `json:"rejected"`
+	Inserted   int64 `json:"inserted"`
+	Duplicates int64 `json:"duplicates"`
+	Errors     int64 `json:"errors"`
+}
+
+// summarize describes the files imported so far, with the exit code the import will return
+func (im *importer) summarize(exitCode int) importSummary {
+	result := importSummary{Started: im.started, Finished: time.Now(), DryRun: im.dryRun, ExitCode: exitCode}
+	result.Duration = result.Finished.Sub(result.Started).Seconds()
+	result.Files = make([]fileSummary, 0)
+	im.statsMutex.Lock()
+	for _, st := range im.stats {
+		fs := fileSummary{
+			File:       st.file,
+			LogName:    st.logname,
+			Skipped:    st.skipped,
+			Compressed: st.compressed,
+			Size:       st.size,
+			Lines:      atomic.LoadInt64(&st.lines),
+			Parsed:     st.parsed,
+			Rejected:   st.rejected,
+			Inserted:   st.inserted,
+			Duplicates: st.duplicates,
+			Errors:     st.errors,
+			Formats:    st.formats,
+			Duration:   st.elapsed.Seconds(),
+			Error:      st.failure,
+		}
+		if fs.Duration > 0 {
+			fs.LinesPerSec = float64(fs.Lines) / fs.Duration
+		}
+		result.Files = append(result.Files, fs)
+
+		t := &result.Totals
+		t.Files++
+		if fs.Skipped {
+			t.Skipped++
+		}
+		if len(fs.Error) > 0 {
+			t.Failed++
+		}
+		t.Lines += fs.Lines
+		t.Parsed += fs.Parsed
+		t.Rejected += fs.Rejected
+		t.Inserted += fs.Inserted
+		t.Duplicates += fs.Duplicates
+		t.Errors += fs.Errors
+	}
+	im.statsMutex.Unlock()
+	// Files are imported concurrently, so they are listed in a stable order
+	sort.SliceStable(result.Files, func(i, j int) bool {
+		return result.Files[i].File < result.Files[j].File
+	})
+	result.Rejects = im.rejectCounts.Counts()
+	result.BadFields = im.badFieldCounts.Counts()
+	result.StoreLatency = im.storeLatency.Summarize()
+	return result
+}
+
+// writeSummary writes the summary of an import as JSON, replacing the file only once it is complete
+func (im *importer) writeSummary(path string, exitCode int) error {
+	data, err := json.MarshalIndent(im.summarize(exitCode), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, append(data, '\n'), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
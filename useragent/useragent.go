@@ -0,0 +1,238 @@
+package useragent
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Other is the family reported when nothing in the regex database matches
+const Other = "Other"
+
+// Device types
+const (
+	Desktop = "desktop"
+	Mobile  = "mobile"
+	Tablet  = "tablet"
+	Bot     = "bot"
+	Unknown = "unknown"
+)
+
+// Client is a user agent split into its parts
+type Client struct {
+	BrowserFamily  string
+	BrowserVersion string
+	OSFamily       string
+	OSVersion      string
+	DeviceFamily   string
+	DeviceBrand    string
+	DeviceModel    string
+	DeviceType     string
+	IsBot          bool
+}
+
+// Parser splits user agents using a regex database in the format of uap-core's regexes.yaml
+type Parser struct {
+	browsers []pattern
+	systems  []pattern
+	devices  []pattern
+}
+
+// pattern is a single entry from the regex database; the meaning of the replacements depends on the section
+type pattern struct {
+	regex        *regexp.Regexp
+	replacements [4]string
+}
+
+type regexFile struct {
+	UserAgentParsers []struct {
+		Regex             string `yaml:"regex"`
+		FamilyReplacement string `yaml:"family_replacement"`
+		V1Replacement     string `yaml:"v1_replacement"`
+		V2Replacement     string `yaml:"v2_replacement"`
+		V3Replacement     string `yaml:"v3_replacement"`
+	} `yaml:"user_agent_parsers"`
+	OSParsers []struct {
+		Regex           string `yaml:"regex"`
+		OSReplacement   string `yaml:"os_replacement"`
+		OSV1Replacement string `yaml:"os_v1_replacement"`
+		OSV2Replacement string `yaml:"os_v2_replacement"`
+		OSV3Replacement string `yaml:"os_v3_replacement"`
+	} `yaml:"os_parsers"`
+	DeviceParsers []struct {
+		Regex             string `yaml:"regex"`
+		RegexFlag         string `yaml:"regex_flag"`
+		DeviceReplacement string `yaml:"device_replacement"`
+		BrandReplacement  string `yaml:"brand_replacement"`
+		ModelReplacement  string `yaml:"model_replacement"`
+	} `yaml:"device_parsers"`
+}
+
+// Load reads a regex database from disk.
+// Patterns that Go's regexp package cannot compile are skipped with a warning.
+func Load(path string) (*Parser, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file regexFile
+	err = yaml.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+
+	result := Parser{}
+	for _, p := range file.UserAgentParsers {
+		result.browsers = appendPattern(result.browsers, p.Regex, "", p.FamilyReplacement, p.V1Replacement, p.V2Replacement, p.V3Replacement)
+	}
+	for _, p := range file.OSParsers {
+		result.systems = appendPattern(result.systems, p.Regex, "", p.OSReplacement, p.OSV1Replacement, p.OSV2Replacement, p.OSV3Replacement)
+	}
+	for _, p := range file.DeviceParsers {
+		result.devices = appendPattern(result.devices, p.Regex, p.RegexFlag, p.DeviceReplacement, p.BrandReplacement, p.ModelReplacement, "")
+	}
+	if len(result.browsers) == 0 && len(result.systems) == 0 && len(result.devices) == 0 {
+		return nil, fmt.Errorf("%v: no user agent patterns found", path)
+	}
+	return &result, nil
+}
+
+func appendPattern(patterns []pattern, expr string, flag string, r0, r1, r2, r3 string) []pattern {
+	if strings.Contains(flag, "i") {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		log.Printf("skipping user agent pattern %q: %v", expr, err)
+		return patterns
+	}
+	return append(patterns, pattern{regex: re, replacements: [4]string{r0, r1, r2, r3}})
+}
+
+// match returns the groups of the first pattern that matches, or nil
+func match(patterns []pattern, ua string) (*pattern, []string) {
+	for i := range patterns {
+		groups := patterns[i].regex.FindStringSubmatch(ua)
+		if groups != nil {
+			return &patterns[i], groups
+		}
+	}
+	return nil, nil
+}
+
+// part returns the nth part of a match: the replacement with $1 to $9 expanded if there is one,
+// otherwise the given capture group
+func part(p *pattern, groups []string, n int, group int) string {
+	r := p.replacements[n]
+	if r == "" {
+		if group >= 0 && group < len(groups) {
+			return strings.TrimSpace(groups[group])
+		}
+		return ""
+	}
+	if strings.IndexByte(r, '$') >= 0 {
+		for i := 1; i < 10; i++ {
+			value := ""
+			if i < len(groups) {
+				value = groups[i]
+			}
+			r = strings.ReplaceAll(r, fmt.Sprintf("$%d", i), value)
+		}
+	}
+	return strings.TrimSpace(r)
+}
+
+// version joins the non-empty parts of a version number
+func version(parts ...string) string {
+	result := ""
+	for _, p := range parts {
+		if p == "" {
+			break
+		}
+		if result != "" {
+			result += "."
+		}
+		result += p
+	}
+	return result
+}
+
+// Parse splits a user agent into browser, operating system and device
+func (p *Parser) Parse(ua string) Client {
+	result := Client{BrowserFamily: Other, OSFamily: Other, DeviceFamily: Other}
+	if pat, groups := match(p.browsers, ua); pat != nil {
+		result.BrowserFamily = part(pat, groups, 0, 1)
+		result.BrowserVersion = version(part(pat, groups, 1, 2), part(pat, groups, 2, 3), part(pat, groups, 3, 4))
+	}
+	if pat, groups := match(p.systems, ua); pat != nil {
+		result.OSFamily = part(pat, groups, 0, 1)
+		result.OSVersion = version(part(pat, groups, 1, 2), part(pat, groups, 2, 3), part(pat, groups, 3, 4))
+	}
+	if pat, groups := match(p.devices, ua); pat != nil {
+		result.DeviceFamily = part(pat, groups, 0, 1)
+		result.DeviceBrand = part(pat, groups, 1, -1)
+		result.DeviceModel = part(pat, groups, 2, 1)
+	}
+	if result.BrowserFamily == "" {
+		result.BrowserFamily = Other
+	}
+	if result.OSFamily == "" {
+		result.OSFamily = Other
+	}
+	if result.DeviceFamily == "" {
+		result.DeviceFamily = Other
+	}
+	result.IsBot = isBot(result, ua)
+	result.DeviceType = deviceType(result)
+	return result
+}
+
+// botWords appear in the user agents of crawlers that the regex database does not recognize as spiders
+var botWords = []string{"bot", "crawl", "spider", "slurp", "fetcher", "scanner", "monitor", "curl/", "wget/", "python-requests", "go-http-client", "libwww-perl", "java/"}
+
+func isBot(c Client, ua string) bool {
+	return c.DeviceFamily == "Spider" || IsBotAgent(ua)
+}
+
+// IsBotAgent reports whether a user agent contains any of the words used by crawlers and command line tools.
+// It needs no regex database, but only recognizes bots that say what they are.
+func IsBotAgent(ua string) bool {
+	lower := strings.ToLower(ua)
+	for _, w := range botWords {
+		if strings.Contains(lower, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// desktopSystems are operating system families that only run on desktop and laptop computers
+var desktopSystems = map[string]bool{
+	"Windows": true, "Mac OS X": true, "Linux": true, "Ubuntu": true, "Fedora": true, "Debian": true,
+	"Chrome OS": true, "FreeBSD": true, "OpenBSD": true, "NetBSD": true, "Solaris": true,
+}
+
+// deviceType classifies a client as a desktop, mobile, tablet or bot
+func deviceType(c Client) string {
+	if c.IsBot {
+		return Bot
+	}
+	lowerFamily := strings.ToLower(c.DeviceFamily)
+	if strings.Contains(lowerFamily, "ipad") || strings.Contains(lowerFamily, "tablet") || strings.Contains(lowerFamily, "kindle") {
+		return Tablet
+	}
+	if c.DeviceFamily == "Mac" {
+		return Desktop
+	}
+	if c.DeviceFamily != Other {
+		return Mobile
+	}
+	if desktopSystems[c.OSFamily] || strings.HasPrefix(c.OSFamily, "Windows") {
+		return Desktop
+	}
+	return Unknown
+}
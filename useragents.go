@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/infodancer/implog/useragent"
+)
+
+// parseUserAgents parses stored user agents with a uap-core regex database,
+// normally for clients imported without one or after the database has been updated
+func parseUserAgents(args []string) int {
+	flags := newFlagSet("useragents", "-uap-regexes file [-all] [flags]")
+	stores := storeFlags(flags)
+	uaRegexes := flags.String("uap-regexes", "", "The uap-core regexes.yaml file used to parse user agents")
+	all := flags.Bool("all", false, "Parse every user agent again, not just those that have not been parsed")
+	flags.Parse(args)
+
+	parser, err := useragent.Load(*uaRegexes)
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+
+	store, err := stores.open()
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	store.SetUserAgentParser(parser)
+	err = store.Init(context.Background())
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	defer store.Close()
+
+	count, err := store.ParseClients(context.Background(), *all)
+	log.Printf("Parsed %v user agents\n", count)
+	return exitStatus(count, err)
+}
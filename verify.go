@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// verify checks the consistency of the store, logging each problem found, for monitoring to run after imports and purges
+func verify(args []string) int {
+	flags := newFlagSet("verify", "[flags]")
+	stores := storeFlags(flags)
+	flags.Parse(args)
+
+	store, err := stores.open()
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	// The store is not initialized, so that verifying never migrates the schema it is checking
+	problems, err := store.Verify(context.Background())
+	for _, p := range problems {
+		log.Println(p)
+	}
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+	if len(problems) > 0 {
+		log.Printf("Found %v problems\n", len(problems))
+		return exitPartial
+	}
+	log.Println("No problems found")
+	return exitOK
+}